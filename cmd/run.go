@@ -22,56 +22,212 @@ import (
 	"fmt"
 	"github.com/drognisep/sshtail/pkg/specfile"
 	"github.com/drognisep/sshtail/pkg/sshtail"
+	"golang.org/x/crypto/ssh/terminal"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+var runLines int
+var runSince string
+var runOutput string
+var runFormat string
+var runColor string
+var runFilter specfile.FilterSpec
+var runHighlight []string
+var runOrderWindow time.Duration
+var runHosts []string
+var runGroups []string
+var runSelector string
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use:   "run",
+	Use:   "run <spec file or directory>",
 	Args:  cobra.ExactArgs(1),
 	Short: "Runs a spec file to connect to multiple hosts and tail the files specified",
 	Long: `Spec files have the extension .spec. A template can be created with
-	sshtail spec init your-spec-name-here`,
+	sshtail spec init your-spec-name-here
+Given a directory, all *.yml spec files in it are merged and run together.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		specFile, err := os.Open(args[0])
+		specData, err := specfile.LoadSpecFile(args[0])
 		if err != nil {
-			return fmt.Errorf("failed to open config file '%s': %w", args[0], err)
+			return fmt.Errorf("unable to parse config file '%s': %w", args[0], err)
 		}
 
-		specData, err := specfile.LoadSpecData(specFile)
+		return tailSpec(cmd, specData)
+	},
+}
+
+// tailSpec tails the hosts of the spec data until interrupted, as configured by the flags added by addTailFlags.
+func tailSpec(cmd *cobra.Command, specData *specfile.SpecData) error {
+	var opts []sshtail.Option
+	if len(runHosts) > 0 || len(runGroups) > 0 || runSelector != "" {
+		var selector *specfile.Selector
+		if runSelector != "" {
+			var err error
+			if selector, err = specfile.ParseSelector(runSelector); err != nil {
+				return err
+			}
+		}
+
+		tags, err := specData.Select(runHosts, runGroups, selector)
 		if err != nil {
-			return fmt.Errorf("unable to parse config file '%s': %w", args[0], err)
+			return err
 		}
+		opts = append(opts, sshtail.WithHosts(tags))
+	}
 
-		writer, err := sshtail.NewConsolidatedWriter(specData, os.Stdout)
+	if cmd.Flags().Changed("lines") {
+		if runLines < 0 {
+			return fmt.Errorf("--lines cannot be negative")
+		}
+		opts = append(opts, sshtail.WithLines(runLines))
+	}
+
+	if runSince != "" {
+		since, err := parseSince(runSince, time.Now())
 		if err != nil {
 			return err
 		}
+		opts = append(opts, sshtail.WithSince(since))
+	}
+
+	if runFilter.Configured() {
+		if err := runFilter.Validate(); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		opts = append(opts, sshtail.WithFilter(&runFilter))
+	}
+
+	if runOrderWindow < 0 {
+		return fmt.Errorf("--order-window cannot be negative")
+	}
+	if runOrderWindow > 0 {
+		opts = append(opts, sshtail.WithOrdering(runOrderWindow))
+	}
+
+	color, err := useColor(runColor, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	highlight, err := sshtail.NewHighlighter(runHighlight)
+	if err != nil {
+		return err
+	}
+
+	var colors *sshtail.HostColors
+	if color {
+		colors = sshtail.NewHostColors(specData)
+	} else {
+		highlight = nil
+	}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	formatter, err := outputFormatter(runOutput, runFormat, colors, highlight)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, sshtail.WithFormatter(formatter))
 
-		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			<-sigs
-			_, _ = fmt.Fprintln(os.Stderr, "Signal received, closing sessions")
-			_ = writer.Close()
-		}()
+	writer, err := sshtail.NewConsolidatedWriter(specData, os.Stdout, opts...)
+	if err != nil {
+		return err
+	}
 
-		_, _ = fmt.Fprintf(os.Stderr, "Started tailing, send interrupt signal to exit\n")
-		if err = writer.Start(ctx); err != nil {
-			return fmt.Errorf("failed to start: %w", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		_, _ = fmt.Fprintln(os.Stderr, "Signal received, closing sessions")
+		_ = writer.Close()
+	}()
+
+	_, _ = fmt.Fprintf(os.Stderr, "Started tailing, send interrupt signal to exit\n")
+	if err = writer.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	return writer.Wait()
+}
+
+// parseSince accepts either a duration relative to now, like 15m, or an RFC 3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since '%s', expected a duration like 15m or an RFC 3339 timestamp", value)
+	}
+
+	return since, nil
+}
+
+// outputFormatter returns the formatter for an output mode, or for a format template when one is given. Text output
+// is painted with the colors and highlights, if there are any.
+func outputFormatter(output string, format string, colors *sshtail.HostColors, highlight *sshtail.Highlighter) (sshtail.Formatter, error) {
+	switch output {
+	case "text":
+		if format != "" {
+			return sshtail.NewTemplateFormatter(format, colors, highlight)
+		}
+		return sshtail.TextFormatter{Colors: colors, Highlight: highlight}, nil
+	case "json":
+		if format != "" {
+			return nil, fmt.Errorf("--format cannot be used with --output json")
 		}
+		return sshtail.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("invalid --output '%s', expected text or json", output)
+	}
+}
 
-		return nil
-	},
+// useColor decides whether output is colored. In auto mode, it's only colored if it's going to a terminal and the
+// NO_COLOR environment variable isn't set.
+func useColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return terminal.IsTerminal(int(out.Fd())), nil
+	default:
+		return false, fmt.Errorf("invalid --color '%s', expected auto, always or never", mode)
+	}
+}
+
+// addTailFlags adds the flags configuring how tailSpec tails the hosts. The ignore case flag only has a shorthand if
+// it's not taken by the command itself.
+func addTailFlags(cmd *cobra.Command, ignoreCaseShorthand string) {
+	cmd.Flags().StringSliceVarP(&runHosts, "hosts", "", nil, "Only tail the hosts with these tags, comma separated or given more than once")
+	cmd.Flags().StringSliceVarP(&runGroups, "group", "", nil, "Only tail the hosts of these groups, comma separated or given more than once")
+	cmd.Flags().StringVarP(&runSelector, "selector", "", "", "Only tail the hosts with matching labels, like role=web,env!=dev")
+	cmd.Flags().IntVarP(&runLines, "lines", "n", 10, "Number of lines to show from the end of each file when tailing starts, overriding the spec")
+	cmd.Flags().StringVarP(&runSince, "since", "", "", "Only show backlog lines logged since a duration ago (like 15m) or an RFC 3339 timestamp. Hosts need a timestamp format")
+	cmd.Flags().DurationVarP(&runOrderWindow, "order-window", "", 0, "Merge the lines of all hosts in the order of their timestamps, holding each line back for this long (like 2s) to wait for earlier lines. Hosts need a timestamp format")
+	cmd.Flags().StringVarP(&runOutput, "output", "o", "text", "Output mode, either text or json for one JSON object per line")
+	cmd.Flags().StringVarP(&runFormat, "format", "", "", "Format of text output, either a Go template over the fields of a line or one of the presets short, long or raw")
+	cmd.Flags().StringArrayVarP(&runFilter.Include, "include", "", nil, "Only show lines matching this regular expression, can be given more than once to show lines matching any of them")
+	cmd.Flags().StringArrayVarP(&runFilter.Exclude, "exclude", "", nil, "Don't show lines matching this regular expression, can be given more than once")
+	cmd.Flags().BoolVarP(&runFilter.IgnoreCase, "ignore-case", ignoreCaseShorthand, false, "Match --include and --exclude regardless of case")
+	cmd.Flags().BoolVarP(&runFilter.Invert, "invert-match", "v", false, "Show the lines --include and --exclude would drop instead")
+	cmd.Flags().StringArrayVarP(&runHighlight, "highlight", "", nil, "Highlight matches of this regular expression within lines when output is colored, can be given more than once to highlight each in a different color")
+	cmd.Flags().StringVarP(&runColor, "color", "", "auto", "Color the host of every line: auto (only when writing to a terminal and NO_COLOR isn't set), always or never")
 }
 
 func init() {
 	specCmd.AddCommand(runCmd)
+
+	addTailFlags(runCmd, "i")
 }
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"15m":                       now.Add(-15 * time.Minute),
+		"1h30m":                     now.Add(-90 * time.Minute),
+		"2023-04-05T06:00:00Z":      time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC),
+		"2023-04-05T08:00:00+02:00": time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC),
+	}
+
+	for value, expected := range tests {
+		since, err := parseSince(value, now)
+		require.NoError(t, err, value)
+		assert.True(t, expected.Equal(since), "%s: expected %v, got %v", value, expected, since)
+	}
+
+	for _, value := range []string{"", "yesterday", "15", "2023-04-05"} {
+		_, err := parseSince(value, now)
+		assert.Error(t, err, "value %q should be rejected", value)
+	}
+}
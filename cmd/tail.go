@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+
+	"github.com/spf13/cobra"
+)
+
+var tailIdentityFile string
+var tailTags []string
+
+// tailCmd represents the tail command
+var tailCmd = &cobra.Command{
+	Use:   "tail [user@]host[:port]:path...",
+	Args:  cobra.MinimumNArgs(1),
+	Short: "Tails files from multiple hosts without a spec file",
+	Long: `Tails the given files like 'ssh host tail -f', but from many hosts at once. Files
+on the same host are tailed over a single connection. Hosts are tagged with
+their hostname, unless tags are given with --tag in the order the hosts first
+appear.
+	sshtail tail web-1:/var/log/syslog me@web-2:2222:/var/log/syslog`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		specData, err := specfile.NewTailSpecData(args, tailIdentityFile, tailTags)
+		if err != nil {
+			return err
+		}
+
+		return tailSpec(cmd, specData)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tailCmd)
+
+	tailCmd.Flags().StringVarP(&tailIdentityFile, "identity", "i", "", "Private key to authenticate with, defaults to ~/.ssh/id_rsa")
+	tailCmd.Flags().StringArrayVarP(&tailTags, "tag", "", nil, "Tag of a host, can be given once for every host in the order they appear")
+	addTailFlags(tailCmd, "")
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// section is embedded in the parts of a host spec that override the defaults when they're given empty, like
+// filters: {}, instead of inheriting them. It remembers whether the part was given in the spec at all.
+type section struct {
+	set bool
+}
+
+// decode decodes the node into plain, the part of the host spec as a type without an UnmarshalYAML method, and
+// remembers that the part was given.
+func (s *section) decode(value *yaml.Node, plain interface{}) error {
+	if err := value.Decode(plain); err != nil {
+		return err
+	}
+	s.set = true
+
+	return nil
+}
+
+// inherit fills in the parameters the host leaves out from the defaults. Files are only inherited if the host sets
+// neither file nor files, and labels are merged with the labels of the host taking precedence. Jump hosts, timestamp,
+// filters and multiline given empty on the host, like jump: [] or filters: {}, override the defaults.
+func (h *HostSpec) inherit(d *HostSpec) {
+	if h.Port == 0 {
+		h.Port = d.Port
+	}
+
+	if h.Username == "" {
+		h.Username = d.Username
+	}
+
+	if h.IdentityFile == "" {
+		h.IdentityFile = d.IdentityFile
+	}
+
+	if h.Auth == "" {
+		h.Auth = d.Auth
+	}
+
+	if h.KnownHostsFile == "" {
+		h.KnownHostsFile = d.KnownHostsFile
+	}
+
+	if h.HostKeyPolicy == "" {
+		h.HostKeyPolicy = d.HostKeyPolicy
+	}
+
+	if h.SshConfig == "" {
+		h.SshConfig = d.SshConfig
+	}
+
+	// Jump hosts and files are filled in when the host is validated, so every host gets its own copy.
+	if h.Jump == nil && d.Jump != nil {
+		h.Jump = make([]*JumpSpec, 0, len(d.Jump))
+		for _, hop := range d.Jump {
+			if hop != nil {
+				c := *hop
+				hop = &c
+			}
+			h.Jump = append(h.Jump, hop)
+		}
+	}
+
+	if h.File == "" && len(h.Files) == 0 {
+		h.File = d.File
+		for _, f := range d.Files {
+			if f != nil {
+				c := *f
+				f = &c
+			}
+			h.Files = append(h.Files, f)
+		}
+	}
+
+	if h.Follow == "" {
+		h.Follow = d.Follow
+	}
+
+	if len(d.Labels) > 0 {
+		labels := make(map[string]string, len(d.Labels)+len(h.Labels))
+		for k, v := range d.Labels {
+			labels[k] = v
+		}
+		for k, v := range h.Labels {
+			labels[k] = v
+		}
+		h.Labels = labels
+	}
+
+	if h.Color == "" {
+		h.Color = d.Color
+	}
+
+	if h.Lines == nil && d.Lines != nil {
+		lines := *d.Lines
+		h.Lines = &lines
+	}
+
+	if !h.Timestamp.set && !h.Timestamp.Configured() {
+		h.Timestamp = d.Timestamp
+	}
+
+	if !h.Filters.set && !h.Filters.Configured() {
+		h.Filters = d.Filters
+	}
+
+	if !h.RemoteFilter.set && !h.RemoteFilter.Configured() {
+		h.RemoteFilter = d.RemoteFilter
+	}
+
+	if !h.Multiline.set && !h.Multiline.Configured() {
+		h.Multiline = d.Multiline
+	}
+
+	if h.KeepaliveInterval == 0 {
+		h.KeepaliveInterval = d.KeepaliveInterval
+	}
+
+	if h.Reconnect.MaxRetries == nil && d.Reconnect.MaxRetries != nil {
+		maxRetries := *d.Reconnect.MaxRetries
+		h.Reconnect.MaxRetries = &maxRetries
+	}
+
+	if h.Reconnect.InitialBackoff == 0 {
+		h.Reconnect.InitialBackoff = d.Reconnect.InitialBackoff
+	}
+
+	if h.Reconnect.MaxBackoff == 0 {
+		h.Reconnect.MaxBackoff = d.Reconnect.MaxBackoff
+	}
+}
+
+// applyDefaults merges the defaults of the groups and then the spec into every host. A host inherits from the groups
+// containing it directly before the groups containing those, so the closest group wins. Two groups with defaults at
+// the same distance from a host would be ambiguous, and are an error.
+func (s *SpecData) applyDefaults() error {
+	if s.Defaults != nil && s.Defaults.Hostname != "" {
+		return errors.New("defaults cannot set a hostname")
+	}
+
+	parents := map[string][]string{}
+	for name, group := range s.Groups {
+		if group.Defaults != nil && group.Defaults.Hostname != "" {
+			return fmt.Errorf("group %s: defaults cannot set a hostname", name)
+		}
+
+		for _, member := range group.Hosts {
+			parents[member] = append(parents[member], name)
+		}
+	}
+
+	for tag, host := range s.Hosts {
+		if host == nil {
+			return fmt.Errorf("host spec %s: cannot be empty", tag)
+		}
+
+		defaults, err := s.groupDefaults(tag, parents)
+		if err != nil {
+			return fmt.Errorf("host spec %s: %w", tag, err)
+		}
+
+		if s.Defaults != nil {
+			defaults = append(defaults, s.Defaults)
+		}
+
+		for _, d := range defaults {
+			host.inherit(d)
+		}
+	}
+
+	return nil
+}
+
+// groupDefaults returns the defaults of the groups containing the host, closest group first.
+func (s *SpecData) groupDefaults(tag string, parents map[string][]string) ([]*HostSpec, error) {
+	var defaults []*HostSpec
+	visited := map[string]bool{}
+
+	level := parents[tag]
+	for len(level) > 0 {
+		sort.Strings(level)
+
+		var found []string
+		var next []string
+		for _, name := range level {
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+
+			if d := s.Groups[name].Defaults; d != nil {
+				found = append(found, name)
+				defaults = append(defaults, d)
+			}
+			next = append(next, parents[name]...)
+		}
+
+		if len(found) > 1 {
+			return nil, fmt.Errorf("inherits defaults from groups %s at once, move the host to one of them only",
+				strings.Join(found, " and "))
+		}
+		level = next
+	}
+
+	return defaults, nil
+}
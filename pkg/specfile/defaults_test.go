@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const defaultsSpec = `
+ssh_config: %s
+defaults:
+  username: spec-user
+  identity_file: /keys/spec
+  file: /var/log/app.log
+  labels: {env: prod, team: ops}
+  lines: 50
+hosts:
+  own:
+    hostname: own-1
+    username: own-user
+    files:
+      - path: /var/log/own.log
+    labels: {env: dev}
+  grouped:
+    hostname: grouped-1
+  nested:
+    hostname: nested-1
+  plain:
+    hostname: plain-1
+groups:
+  web:
+    hosts: [grouped, inner]
+    defaults:
+      username: web-user
+      port: 2022
+  inner:
+    hosts: [nested]
+    defaults:
+      username: inner-user
+`
+
+const defaultsSshConfig = `
+Host *
+  User config-user
+  Port 2200
+  IdentityFile /keys/config
+`
+
+func loadDefaultsSpec(t *testing.T, spec string) (*SpecData, error) {
+	sshConfig := path.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(sshConfig, []byte(defaultsSshConfig), 0644))
+
+	return LoadSpecData(strings.NewReader(fmt.Sprintf(spec, sshConfig)))
+}
+
+func TestSpecData_Defaults(t *testing.T) {
+	/// Given
+	/// When
+	specData, err := loadDefaultsSpec(t, defaultsSpec)
+
+	/// Then
+	require.NoError(t, err)
+	own, grouped, nested, plain := specData.Hosts["own"], specData.Hosts["grouped"], specData.Hosts["nested"], specData.Hosts["plain"]
+
+	assert.Equal(t, "own-user", own.Username, "the host takes precedence")
+	assert.Equal(t, "web-user", grouped.Username, "group defaults take precedence over the spec defaults")
+	assert.Equal(t, "inner-user", nested.Username, "the closest group takes precedence")
+	assert.Equal(t, "spec-user", plain.Username, "spec defaults take precedence over ssh_config")
+
+	assert.Equal(t, 2022, grouped.Port)
+	assert.Equal(t, 2022, nested.Port, "defaults of a group are inherited through the groups it contains")
+	assert.Equal(t, 2200, plain.Port, "ssh_config takes precedence over built-in defaults")
+	assert.Equal(t, "/keys/spec", plain.IdentityFile)
+	assert.Equal(t, DefaultKeepaliveInterval, plain.KeepaliveInterval, "built-in defaults come last")
+
+	require.Len(t, own.Files, 1, "files are only inherited if the host has none")
+	assert.Equal(t, "/var/log/own.log", own.Files[0].Path)
+	require.Len(t, plain.Files, 1)
+	assert.Equal(t, "/var/log/app.log", plain.Files[0].Path)
+
+	assert.Equal(t, map[string]string{"env": "dev", "team": "ops"}, own.Labels, "labels are merged")
+	require.NotNil(t, plain.Lines)
+	assert.Equal(t, 50, *plain.Lines)
+}
+
+func TestSpecData_DefaultsMaxRetries(t *testing.T) {
+	/// Given
+	spec := `
+ssh_config: %s
+defaults:
+  reconnect: {max_retries: 5}
+hosts:
+  forever: {hostname: a, file: /log, reconnect: {max_retries: 0}}
+  never: {hostname: b, file: /log, reconnect: {max_retries: -1}}
+  inherited: {hostname: c, file: /log}
+`
+
+	/// When
+	specData, err := loadDefaultsSpec(t, spec)
+
+	/// Then
+	require.NoError(t, err)
+	forever, never, inherited := specData.Hosts["forever"], specData.Hosts["never"], specData.Hosts["inherited"]
+
+	require.NotNil(t, forever.Reconnect.MaxRetries)
+	assert.Equal(t, 0, *forever.Reconnect.MaxRetries, "an explicit 0 on the host takes precedence")
+	assert.True(t, forever.Reconnect.Enabled())
+	assert.Equal(t, 0, forever.Reconnect.Retries())
+
+	assert.False(t, never.Reconnect.Enabled())
+
+	require.NotNil(t, inherited.Reconnect.MaxRetries)
+	assert.Equal(t, 5, inherited.Reconnect.Retries(), "max_retries is inherited if the host doesn't set it")
+}
+
+func TestSpecData_DefaultsOverriddenByEmpty(t *testing.T) {
+	tests := map[string]struct {
+		defaults string
+		empty    string
+		given    func(h *HostSpec) bool
+	}{
+		"jump": {defaults: "jump: [bastion]", empty: "jump: []",
+			given: func(h *HostSpec) bool { return len(h.Jump) > 0 }},
+		"timestamp": {defaults: "timestamp: {layout: syslog}", empty: "timestamp: {}",
+			given: func(h *HostSpec) bool { return h.Timestamp.Configured() }},
+		"filters": {defaults: "filters: {include: [error]}", empty: "filters: {include: []}",
+			given: func(h *HostSpec) bool { return h.Filters.Configured() }},
+		"remote_filter": {defaults: "remote_filter: {exclude: [debug]}", empty: "remote_filter: {}",
+			given: func(h *HostSpec) bool { return h.RemoteFilter.Configured() }},
+		"multiline": {defaults: `multiline: {start: '^\S'}`, empty: "multiline: {}",
+			given: func(h *HostSpec) bool { return h.Multiline.Configured() }},
+	}
+
+	for name, tc := range tests {
+		/// Given
+		spec := `
+ssh_config: %s
+defaults:
+  ` + tc.defaults + `
+hosts:
+  empty: {hostname: a, file: /log, ` + tc.empty + `}
+  inherited: {hostname: b, file: /log}
+`
+
+		/// When
+		specData, err := loadDefaultsSpec(t, spec)
+
+		/// Then
+		require.NoError(t, err, name)
+		assert.False(t, tc.given(specData.Hosts["empty"]), "%s given empty on the host should override the defaults", name)
+		assert.True(t, tc.given(specData.Hosts["inherited"]), "%s should be inherited if the host leaves it out", name)
+	}
+}
+
+func TestSpecData_DefaultsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"hostname": `
+ssh_config: %s
+defaults:
+  hostname: everywhere
+hosts:
+  a: {hostname: a, file: /log}
+`,
+		"group hostname": `
+ssh_config: %s
+hosts:
+  a: {hostname: a, file: /log}
+groups:
+  g: {hosts: [a], defaults: {hostname: everywhere}}
+`,
+		"ambiguous groups": `
+ssh_config: %s
+hosts:
+  a: {hostname: a, file: /log}
+groups:
+  g1: {hosts: [a], defaults: {username: one}}
+  g2: {hosts: [a], defaults: {username: two}}
+`,
+		"invalid default": `
+ssh_config: %s
+defaults:
+  follow: sideways
+hosts:
+  a: {hostname: a, file: /log}
+`,
+	}
+	for name, spec := range tests {
+		/// When
+		_, err := loadDefaultsSpec(t, spec)
+
+		/// Then
+		assert.Error(t, err, name)
+	}
+}
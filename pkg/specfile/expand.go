@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// expandHome expands a leading ~ to the home directory of the current user.
+func expandHome(value string) string {
+	if value != "~" && !strings.HasPrefix(value, "~/") {
+		return value
+	}
+
+	home, err := homeDir()
+	if err != nil {
+		return value
+	}
+
+	return home + value[1:]
+}
+
+// expandEnv replaces ${VAR} with the value of the environment variable, and ${VAR:-default} with the default if the
+// variable is unset or empty. $${ is left as a literal ${. The names of variables that are referenced without a
+// default but aren't set are returned.
+func expandEnv(value string) (string, []string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil, nil
+	}
+
+	var b strings.Builder
+	var undefined []string
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			b.WriteString(value)
+			break
+		}
+
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i-1] + "${")
+			value = value[i+2:]
+			continue
+		}
+		b.WriteString(value[:i])
+
+		end := strings.Index(value[i:], "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("unterminated ${ in '%s'", value)
+		}
+		ref := value[i+2 : i+end]
+		value = value[i+end+1:]
+
+		name, def, hasDefault := ref, "", false
+		if j := strings.Index(ref, ":-"); j >= 0 {
+			name, def, hasDefault = ref[:j], ref[j+2:], true
+		}
+		if !validEnvName(name) {
+			return "", nil, fmt.Errorf("invalid variable name '%s'", name)
+		}
+
+		v, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && v == "":
+			b.WriteString(def)
+		case ok:
+			b.WriteString(v)
+		default:
+			undefined = append(undefined, name)
+		}
+	}
+
+	return b.String(), undefined, nil
+}
+
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// specExpander expands the string values of a spec, collecting the undefined variables so they can all be reported
+// at once.
+type specExpander struct {
+	undefined map[string]bool
+	err       error
+}
+
+func (e *specExpander) expand(value *string) {
+	if e.err != nil {
+		return
+	}
+
+	expanded, undefined, err := expandEnv(*value)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	for _, name := range undefined {
+		e.undefined[name] = true
+	}
+	*value = expanded
+}
+
+// path expands a local path, which may also start with ~.
+func (e *specExpander) path(value *string) {
+	e.expand(value)
+	*value = expandHome(*value)
+}
+
+func (e *specExpander) host(h *HostSpec) {
+	if h == nil {
+		return
+	}
+
+	e.expand(&h.Hostname)
+	e.expand(&h.Username)
+	e.path(&h.IdentityFile)
+	e.expand(&h.Auth)
+	e.path(&h.KnownHostsFile)
+	e.expand(&h.HostKeyPolicy)
+	e.path(&h.SshConfig)
+	e.expand(&h.File)
+	e.expand(&h.Follow)
+	e.expand(&h.Color)
+
+	for _, hop := range h.Jump {
+		if hop == nil {
+			continue
+		}
+		e.expand(&hop.Hostname)
+		e.expand(&hop.Username)
+		e.path(&hop.IdentityFile)
+		e.expand(&hop.Auth)
+		e.path(&hop.KnownHostsFile)
+		e.expand(&hop.HostKeyPolicy)
+	}
+
+	for _, f := range h.Files {
+		if f == nil {
+			continue
+		}
+		e.expand(&f.Path)
+		e.expand(&f.Tag)
+	}
+
+	for k, v := range h.Labels {
+		e.expand(&v)
+		h.Labels[k] = v
+	}
+}
+
+// expand expands environment variables in the string values of the spec, and ~ in its local paths. Patterns aren't
+// expanded, since $ has a meaning of its own in regular expressions.
+func (s *SpecData) expand() error {
+	e := &specExpander{undefined: map[string]bool{}}
+
+	e.path(&s.SshConfig)
+	e.host(s.Defaults)
+	for tag, host := range s.Hosts {
+		e.host(host)
+		if e.err != nil {
+			return fmt.Errorf("host spec %s: %w", tag, e.err)
+		}
+	}
+	for name, group := range s.Groups {
+		if group != nil {
+			e.host(group.Defaults)
+		}
+		if e.err != nil {
+			return fmt.Errorf("group %s: %w", name, e.err)
+		}
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+
+	if len(e.undefined) > 0 {
+		var names []string
+		for name := range e.undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("undefined environment variables %s, set them or give a default like ${VAR:-default}",
+			strings.Join(names, ", "))
+	}
+
+	return nil
+}
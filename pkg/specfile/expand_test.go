@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("SSHTAIL_USER", "deploy")
+	t.Setenv("SSHTAIL_EMPTY", "")
+
+	tests := map[string]string{
+		"plain":               "plain",
+		"${SSHTAIL_USER}":     "deploy",
+		"a-${SSHTAIL_USER}":   "a-deploy",
+		"${SSHTAIL_USER:-x}":  "deploy",
+		"${SSHTAIL_UNSET:-x}": "x",
+		"${SSHTAIL_EMPTY:-x}": "x",
+		"${SSHTAIL_EMPTY}":    "",
+		"${SSHTAIL_UNSET:-}":  "",
+		"$${SSHTAIL_USER}":    "${SSHTAIL_USER}",
+		"$SSHTAIL_USER":       "$SSHTAIL_USER",
+		"^error$":             "^error$",
+	}
+	for value, expected := range tests {
+		/// When
+		expanded, undefined, err := expandEnv(value)
+
+		/// Then
+		require.NoError(t, err, value)
+		assert.Empty(t, undefined, value)
+		assert.Equal(t, expected, expanded, value)
+	}
+}
+
+func TestExpandEnv_Invalid(t *testing.T) {
+	for _, value := range []string{"${SSHTAIL_USER", "${}", "${1X}", "${A B}"} {
+		_, _, err := expandEnv(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadSpecData_Expand(t *testing.T) {
+	/// Given
+	t.Setenv("SSHTAIL_USER", "deploy")
+	t.Setenv("SSHTAIL_DOMAIN", "example.com")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	spec := `
+defaults:
+  username: ${SSHTAIL_USER}
+  identity_file: ~/.ssh/id_ed25519
+  known_hosts_file: ${SSHTAIL_KNOWN_HOSTS:-~/known_hosts}
+hosts:
+  web:
+    hostname: web.${SSHTAIL_DOMAIN}
+    ssh_config: /dev/null
+    file: /var/log/${SSHTAIL_APP:-app}.log
+    jump:
+      - hostname: bastion.${SSHTAIL_DOMAIN}
+        identity_file: ~/.ssh/bastion
+    timestamp:
+      layout: rfc3339
+      pattern: '^(\S+)$'
+`
+
+	/// When
+	specData, err := LoadSpecData(strings.NewReader(spec))
+
+	/// Then
+	require.NoError(t, err)
+	web := specData.Hosts["web"]
+	assert.Equal(t, "web.example.com", web.Hostname)
+	assert.Equal(t, "deploy", web.Username)
+	assert.Equal(t, home+"/.ssh/id_ed25519", web.IdentityFile)
+	assert.Equal(t, home+"/known_hosts", web.KnownHostsFile)
+	assert.Equal(t, "/var/log/app.log", web.Files[0].Path)
+	assert.Equal(t, "bastion.example.com", web.Jump[0].Hostname)
+	assert.Equal(t, home+"/.ssh/bastion", web.Jump[0].IdentityFile)
+	assert.Equal(t, `^(\S+)$`, web.Timestamp.Pattern, "patterns are not expanded")
+}
+
+func TestLoadSpecData_ExpandUndefined(t *testing.T) {
+	/// Given
+	spec := `
+hosts:
+  web:
+    hostname: ${SSHTAIL_UNSET_HOST}
+    username: ${SSHTAIL_UNSET_USER}
+    file: /var/log/${SSHTAIL_UNSET_HOST}.log
+`
+
+	/// When
+	_, err := LoadSpecData(strings.NewReader(spec))
+
+	/// Then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined environment variables SSHTAIL_UNSET_HOST, SSHTAIL_UNSET_USER,")
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSpec encapsulates a single file to tail on a host.
+type FileSpec struct {
+	Path string `yaml:"path"`
+	// Tag identifies the file in the output, defaulting to the file's base name.
+	Tag string `yaml:"tag"`
+
+	// defaultTag is set if the tag was left out, and defaulted to the file's base name.
+	defaultTag bool
+}
+
+// UnmarshalYAML allows a file to be given as just its path.
+func (f *FileSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = FileSpec{Path: value.Value}
+		return nil
+	}
+
+	type plain FileSpec
+	return value.Decode((*plain)(f))
+}
+
+// TagSet returns true if the tag of the file was configured, rather than defaulted to the file's base name.
+func (f *FileSpec) TagSet() bool {
+	return f.Tag != "" && !f.defaultTag
+}
+
+// DefaultTag returns the tag used for the file if none is configured.
+func (f *FileSpec) DefaultTag() string {
+	return path.Base(f.Path)
+}
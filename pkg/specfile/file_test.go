@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestHostSpec_Files(t *testing.T) {
+	/// Given
+	spec := `
+ssh_config: /dev/null
+hosts:
+  web:
+    hostname: web-1
+    files:
+      - /var/log/nginx/access.log
+      - path: /var/log/nginx/error.log
+        tag: errors
+      - path: /var/log/app/access.log
+        tag: app
+`
+
+	/// When
+	specData, err := LoadSpecData(strings.NewReader(spec))
+
+	/// Then
+	require.NoError(t, err)
+	files := specData.Hosts["web"].Files
+	require.Len(t, files, 3)
+	assert.Equal(t, FileSpec{Path: "/var/log/nginx/access.log", Tag: "access.log", defaultTag: true}, *files[0], "a scalar should be the path")
+	assert.Equal(t, FileSpec{Path: "/var/log/nginx/error.log", Tag: "errors"}, *files[1])
+	assert.Equal(t, FileSpec{Path: "/var/log/app/access.log", Tag: "app"}, *files[2], "the same base name can be used with a distinct tag")
+}
+
+func TestHostSpec_FileShorthand(t *testing.T) {
+	/// Given
+	host := &HostSpec{Hostname: "web-1", File: "/var/log/syslog"}
+
+	/// When
+	err := host.Validate()
+
+	/// Then
+	require.NoError(t, err)
+	assert.Empty(t, host.File)
+	require.Len(t, host.Files, 1)
+	assert.Equal(t, FileSpec{Path: "/var/log/syslog", Tag: "syslog", defaultTag: true}, *host.Files[0])
+}
+
+func TestFileSpec_TagSet(t *testing.T) {
+	/// Given
+	spec := `
+ssh_config: /dev/null
+hosts:
+  tagged:
+    hostname: web-1
+    files:
+      - path: /var/log/nginx/error.log
+        tag: errors
+  untagged:
+    hostname: web-2
+    files:
+      - /var/log/nginx/error.log
+  shorthand:
+    hostname: web-3
+    file: /var/log/nginx/error.log
+`
+
+	/// When
+	specData, err := LoadSpecData(strings.NewReader(spec))
+
+	/// Then
+	require.NoError(t, err)
+	assert.True(t, specData.Hosts["tagged"].Files[0].TagSet(), "a single file's tag should be kept if it's set")
+	assert.False(t, specData.Hosts["untagged"].Files[0].TagSet())
+	assert.False(t, specData.Hosts["shorthand"].Files[0].TagSet())
+
+	/// When
+	host := specData.Hosts["untagged"]
+	require.NoError(t, host.Validate())
+
+	/// Then
+	assert.False(t, host.Files[0].TagSet(), "validating again should not make the default tag look configured")
+}
+
+func TestHostSpec_FilesInvalid(t *testing.T) {
+	tests := map[string]*HostSpec{
+		"no files":       {Hostname: "web-1"},
+		"file and files": {Hostname: "web-1", File: "/var/log/syslog", Files: []*FileSpec{{Path: "/var/log/auth.log"}}},
+		"duplicate tags": {Hostname: "web-1", Files: []*FileSpec{{Path: "/var/log/nginx/access.log"}, {Path: "/var/log/app/access.log"}}},
+		"duplicate explicit tags": {Hostname: "web-1", Files: []*FileSpec{
+			{Path: "/var/log/syslog", Tag: "sys"}, {Path: "/var/log/messages", Tag: "sys"}}},
+		"blank path": {Hostname: "web-1", Files: []*FileSpec{{Path: "/var/log/syslog"}, {Tag: "empty"}}},
+		"empty file": {Hostname: "web-1", Files: []*FileSpec{nil}},
+	}
+
+	for name, host := range tests {
+		/// When
+		err := host.Validate()
+
+		/// Then
+		assert.Error(t, err, name)
+	}
+}
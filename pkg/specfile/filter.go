@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FilterSpec selects the lines to show by regular expressions matched against the line.
+type FilterSpec struct {
+	// Include only passes lines matching at least one of the patterns. Every line passes if it's empty.
+	Include []string `yaml:"include"`
+	// Exclude drops lines matching any of the patterns, even if they're included.
+	Exclude []string `yaml:"exclude"`
+	// IgnoreCase matches the patterns regardless of case.
+	IgnoreCase bool `yaml:"ignore_case"`
+	// Invert passes the lines that would otherwise be dropped, and drops the rest.
+	Invert bool `yaml:"invert"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	section
+}
+
+// UnmarshalYAML marks the filter as given, so a filter without patterns passes every line even if the defaults filter.
+func (f *FilterSpec) UnmarshalYAML(value *yaml.Node) error {
+	type plain FilterSpec
+	return f.decode(value, (*plain)(f))
+}
+
+// Configured returns true if the filter has any patterns.
+func (f *FilterSpec) Configured() bool {
+	return len(f.Include) > 0 || len(f.Exclude) > 0
+}
+
+// Validate compiles the patterns of the FilterSpec.
+func (f *FilterSpec) Validate() error {
+	var err error
+	if f.include, err = f.compile(f.Include); err != nil {
+		return fmt.Errorf("include: %w", err)
+	}
+	if f.exclude, err = f.compile(f.Exclude); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+
+	return nil
+}
+
+func (f *FilterSpec) compile(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if f.IgnoreCase {
+			p = "(?i)" + p
+		}
+
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// Match returns true if the line passes the filter. Every line passes before the FilterSpec is validated.
+func (f *FilterSpec) Match(line []byte) bool {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return true
+	}
+
+	return f.match(line) != f.Invert
+}
+
+func (f *FilterSpec) match(line []byte) bool {
+	for _, re := range f.exclude {
+		if re.Match(line) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.Match(line) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFilterSpec_Match(t *testing.T) {
+	lines := []string{"INFO started", "ERROR failed", "error: disk full", "WARN slow", "DEBUG healthcheck error"}
+
+	tests := map[string]struct {
+		filter   FilterSpec
+		expected []string
+	}{
+		"none": {
+			filter:   FilterSpec{},
+			expected: lines,
+		},
+		"include": {
+			filter:   FilterSpec{Include: []string{`ERROR`, `WARN`}},
+			expected: []string{"ERROR failed", "WARN slow"},
+		},
+		"exclude": {
+			filter:   FilterSpec{Exclude: []string{`^DEBUG`}},
+			expected: []string{"INFO started", "ERROR failed", "error: disk full", "WARN slow"},
+		},
+		"include and exclude ignoring case": {
+			filter:   FilterSpec{Include: []string{`error`}, Exclude: []string{`^debug`}, IgnoreCase: true},
+			expected: []string{"ERROR failed", "error: disk full"},
+		},
+		"invert": {
+			filter:   FilterSpec{Include: []string{`error`}, Invert: true},
+			expected: []string{"INFO started", "ERROR failed", "WARN slow"},
+		},
+	}
+	for name, tc := range tests {
+		/// Given
+		filter := tc.filter
+		require.NoError(t, filter.Validate(), name)
+
+		/// When
+		var matched []string
+		for _, line := range lines {
+			if filter.Match([]byte(line)) {
+				matched = append(matched, line)
+			}
+		}
+
+		/// Then
+		assert.Equal(t, tc.expected, matched, name)
+	}
+}
+
+func TestFilterSpec_ValidateInvalid(t *testing.T) {
+	filter := FilterSpec{Exclude: []string{`(`}}
+	assert.Error(t, filter.Validate())
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GroupSpec is a named set of hosts, so they can be selected together. Members are host tags or the names of other
+// groups.
+type GroupSpec struct {
+	Hosts []string `yaml:"hosts"`
+	// Defaults are inherited by the hosts of the group, including those of the groups it contains.
+	Defaults *HostSpec `yaml:"defaults"`
+}
+
+// UnmarshalYAML allows a group to be given as just the list of its members.
+func (g *GroupSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		*g = GroupSpec{}
+		return value.Decode(&g.Hosts)
+	}
+
+	type plain GroupSpec
+	return value.Decode((*plain)(g))
+}
+
+// validateGroups checks that the members of every group exist, and that no group contains itself.
+func (s *SpecData) validateGroups() error {
+	for name, group := range s.Groups {
+		if _, ok := s.Hosts[name]; ok {
+			return fmt.Errorf("group %s: a host has the same tag", name)
+		}
+
+		if group == nil {
+			return fmt.Errorf("group %s: cannot be empty", name)
+		}
+
+		if _, err := s.groupHosts(name, nil); err != nil {
+			return fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// groupHosts returns the tags of all hosts in a group, including the hosts of the groups it contains. Visiting holds
+// the groups being resolved, to detect a group containing itself.
+func (s *SpecData) groupHosts(name string, visiting []string) ([]string, error) {
+	for _, v := range visiting {
+		if v == name {
+			return nil, fmt.Errorf("group %s contains itself through %s", name, strings.Join(append(visiting, name), " > "))
+		}
+	}
+
+	group, ok := s.Groups[name]
+	if !ok || group == nil {
+		return nil, fmt.Errorf("unknown group '%s'", name)
+	}
+
+	var tags []string
+	for _, member := range group.Hosts {
+		if _, ok := s.Hosts[member]; ok {
+			tags = append(tags, member)
+			continue
+		}
+
+		if _, ok := s.Groups[member]; !ok {
+			return nil, fmt.Errorf("unknown host or group '%s'", member)
+		}
+
+		members, err := s.groupHosts(member, append(visiting, name))
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, members...)
+	}
+
+	return tags, nil
+}
+
+// Selector matches hosts by their labels. It's given as a comma separated list of key=value or key!=value
+// requirements, all of which a host has to meet.
+type Selector struct {
+	requirements []labelRequirement
+}
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+}
+
+// ParseSelector parses a selector like role=web,env!=dev.
+func ParseSelector(s string) (*Selector, error) {
+	selector := &Selector{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		req := labelRequirement{}
+		if i := strings.Index(part, "!="); i >= 0 {
+			req.key, req.value, req.negate = part[:i], part[i+2:], true
+		} else if i := strings.Index(part, "="); i >= 0 {
+			req.key, req.value = part[:i], part[i+1:]
+		} else {
+			return nil, fmt.Errorf("invalid selector '%s': expected key=value or key!=value", part)
+		}
+
+		req.key, req.value = strings.TrimSpace(req.key), strings.TrimSpace(req.value)
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid selector '%s': blank label", part)
+		}
+		selector.requirements = append(selector.requirements, req)
+	}
+
+	if len(selector.requirements) == 0 {
+		return nil, errors.New("selector cannot be empty")
+	}
+
+	return selector, nil
+}
+
+// Matches returns true if the labels meet all requirements of the selector.
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		value, ok := labels[req.key]
+		if (ok && value == req.value) == req.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Select returns the tags of the selected hosts, sorted. Hosts given by tag and the hosts of the given groups are
+// selected, or all hosts if neither are given. The selector, if not nil, narrows the selection down to the hosts
+// matching it.
+func (s *SpecData) Select(hosts []string, groups []string, selector *Selector) ([]string, error) {
+	selected := map[string]bool{}
+	for _, tag := range hosts {
+		if _, ok := s.Hosts[tag]; !ok {
+			return nil, fmt.Errorf("unknown host '%s'", tag)
+		}
+		selected[tag] = true
+	}
+
+	for _, name := range groups {
+		tags, err := s.groupHosts(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			selected[tag] = true
+		}
+	}
+
+	if len(hosts) == 0 && len(groups) == 0 {
+		for tag := range s.Hosts {
+			selected[tag] = true
+		}
+	}
+
+	var tags []string
+	for tag := range selected {
+		if selector == nil || selector.Matches(s.Hosts[tag].Labels) {
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, errors.New("no hosts selected")
+	}
+
+	sort.Strings(tags)
+	return tags, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const groupSpec = `
+ssh_config: /dev/null
+hosts:
+  web1:
+    hostname: web-1
+    file: /var/log/syslog
+    labels: {role: web, env: prod}
+  web2:
+    hostname: web-2
+    file: /var/log/syslog
+    labels: {role: web, env: dev}
+  worker1:
+    hostname: worker-1
+    file: /var/log/syslog
+    labels: {role: worker, env: prod}
+  db1:
+    hostname: db-1
+    file: /var/log/syslog
+    labels: {role: db, env: prod}
+groups:
+  web: [web1, web2]
+  backend:
+    hosts: [worker1, db1]
+  all: [web, backend]
+`
+
+func TestSpecData_Select(t *testing.T) {
+	/// Given
+	specData, err := LoadSpecData(strings.NewReader(groupSpec))
+	require.NoError(t, err)
+	prod, err := ParseSelector("env=prod")
+	require.NoError(t, err)
+	notWeb, err := ParseSelector("role!=web, env=prod")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		hosts    []string
+		groups   []string
+		selector *Selector
+		expected []string
+	}{
+		"all":                {expected: []string{"db1", "web1", "web2", "worker1"}},
+		"hosts":              {hosts: []string{"web2", "db1"}, expected: []string{"db1", "web2"}},
+		"group":              {groups: []string{"backend"}, expected: []string{"db1", "worker1"}},
+		"groups of groups":   {groups: []string{"all"}, expected: []string{"db1", "web1", "web2", "worker1"}},
+		"hosts and groups":   {hosts: []string{"db1"}, groups: []string{"web"}, expected: []string{"db1", "web1", "web2"}},
+		"selector":           {selector: prod, expected: []string{"db1", "web1", "worker1"}},
+		"group and selector": {groups: []string{"web"}, selector: prod, expected: []string{"web1"}},
+		"negated selector":   {selector: notWeb, expected: []string{"db1", "worker1"}},
+	}
+	for name, tc := range tests {
+		/// When
+		tags, err := specData.Select(tc.hosts, tc.groups, tc.selector)
+
+		/// Then
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.expected, tags, name)
+	}
+
+	_, err = specData.Select([]string{"nope"}, nil, nil)
+	assert.Error(t, err)
+	_, err = specData.Select(nil, []string{"nope"}, nil)
+	assert.Error(t, err)
+	_, err = specData.Select([]string{"web1"}, nil, notWeb)
+	assert.Error(t, err, "an empty selection should be an error")
+}
+
+func TestSpecData_ValidateGroups(t *testing.T) {
+	invalid := map[string]string{
+		"unknown member": "groups:\n  web: [web1, nope]\n",
+		"cycle":          "groups:\n  a: [b]\n  b: [a]\n",
+		"same as a host": "groups:\n  web1: [web1]\n",
+	}
+	for name, groups := range invalid {
+		_, err := LoadSpecData(strings.NewReader("ssh_config: /dev/null\nhosts:\n  web1:\n    hostname: web-1\n    file: /var/log/syslog\n" + groups))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, s := range []string{"", "role", "=web", " , "} {
+		_, err := ParseSelector(s)
+		assert.Error(t, err, "selector %q should be invalid", s)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specLoader merges spec files into one SpecData, remembering the file each host and group came from to report
+// conflicts.
+type specLoader struct {
+	merged *SpecData
+	hosts  map[string]string
+	groups map[string]string
+	// defaults and sshConfig are the files setting the spec wide defaults and ssh_config, if any did.
+	defaults  string
+	sshConfig string
+	// loaded holds the absolute paths of the files loaded so far, so every file is only loaded once.
+	loaded map[string]bool
+}
+
+func newSpecLoader() *specLoader {
+	return &specLoader{
+		merged: &SpecData{Hosts: map[string]*HostSpec{}},
+		hosts:  map[string]string{},
+		groups: map[string]string{},
+		loaded: map[string]bool{},
+	}
+}
+
+// loadPath loads a spec file, or all *.yml files in a directory.
+func (l *specLoader) loadPath(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("unable to read spec file: %w", err)
+	}
+
+	if !info.IsDir() {
+		return l.loadFile(name)
+	}
+
+	files, err := filepath.Glob(filepath.Join(name, "*.yml"))
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no *.yml spec files in %s", name)
+	}
+
+	for _, file := range files {
+		if err = l.loadFile(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *specLoader) loadFile(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("unable to read spec file: %w", err)
+	}
+
+	specData := &SpecData{}
+	if err = yaml.Unmarshal(data, specData); err != nil {
+		return fmt.Errorf("invalid spec data format in %s: %w", name, err)
+	}
+
+	return l.add(specData, name, filepath.Dir(name))
+}
+
+// add merges the spec data from the named file into the result, then loads the files it includes relative to dir.
+func (l *specLoader) add(specData *SpecData, name string, dir string) error {
+	for tag, host := range specData.Hosts {
+		if other, ok := l.hosts[tag]; ok {
+			return fmt.Errorf("host %s is defined in both %s and %s", tag, other, name)
+		}
+		l.hosts[tag] = name
+		l.merged.Hosts[tag] = host
+	}
+
+	for group, spec := range specData.Groups {
+		if other, ok := l.groups[group]; ok {
+			return fmt.Errorf("group %s is defined in both %s and %s", group, other, name)
+		}
+		l.groups[group] = name
+		if l.merged.Groups == nil {
+			l.merged.Groups = map[string]*GroupSpec{}
+		}
+		l.merged.Groups[group] = spec
+	}
+
+	if specData.Defaults != nil {
+		if l.defaults != "" {
+			return fmt.Errorf("defaults are set in both %s and %s, only one file may set them", l.defaults, name)
+		}
+		l.defaults = name
+		l.merged.Defaults = specData.Defaults
+	}
+
+	if specData.SshConfig != "" {
+		if l.sshConfig != "" {
+			return fmt.Errorf("ssh_config is set in both %s and %s, only one file may set it", l.sshConfig, name)
+		}
+		l.sshConfig = name
+		l.merged.SshConfig = specData.SshConfig
+	}
+
+	for _, pattern := range specData.Include {
+		pattern = expandHome(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include '%s': %w", name, pattern, err)
+		}
+
+		// A pattern without wildcards names a file that has to exist, a pattern with wildcards may match nothing.
+		if len(files) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+			return fmt.Errorf("%s: included file %s doesn't exist", name, pattern)
+		}
+
+		for _, file := range files {
+			if err = l.loadPath(file); err != nil {
+				return fmt.Errorf("%s: include %s: %w", name, file, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadSpecFile reads a spec file, or a directory of *.yml spec files, along with the files they include. The files
+// are merged into one SpecData, which is then expanded and validated like by LoadSpecData. A host or group may only be
+// defined in one of the files.
+func LoadSpecFile(name string) (*SpecData, error) {
+	l := newSpecLoader()
+	if err := l.loadPath(name); err != nil {
+		return nil, err
+	}
+
+	return finishSpecData(l.merged)
+}
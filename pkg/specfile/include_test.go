@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSpecFiles writes the spec files, given by their path relative to a temporary directory, and returns the
+// directory.
+func writeSpecFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func TestLoadSpecFile_Include(t *testing.T) {
+	/// Given
+	dir := writeSpecFiles(t, map[string]string{
+		"main.yml": `
+include:
+  - teams/web.yml
+  - teams/db-*.yml
+ssh_config: /dev/null
+defaults:
+  file: /var/log/syslog
+groups:
+  everything: [web, db]
+`,
+		"teams/web.yml": `
+include: [../main.yml]
+hosts:
+  web1: {hostname: web-1}
+groups:
+  web: [web1]
+`,
+		"teams/db-primary.yml": `
+hosts:
+  db1: {hostname: db-1}
+groups:
+  db: [db1, db2]
+`,
+		"teams/db-replica.yml": `
+hosts:
+  db2: {hostname: db-2}
+`,
+	})
+
+	/// When
+	specData, err := LoadSpecFile(filepath.Join(dir, "main.yml"))
+
+	/// Then
+	require.NoError(t, err)
+	assert.Len(t, specData.Hosts, 3)
+	assert.Equal(t, "/var/log/syslog", specData.Hosts["db2"].Files[0].Path, "defaults apply to the hosts of all files")
+	tags, err := specData.Select(nil, []string{"everything"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db1", "db2", "web1"}, tags)
+}
+
+func TestLoadSpecFile_Directory(t *testing.T) {
+	/// Given
+	dir := writeSpecFiles(t, map[string]string{
+		"web.yml":   "hosts:\n  web1: {hostname: web-1, file: /log, ssh_config: /dev/null}\n",
+		"db.yml":    "hosts:\n  db1: {hostname: db-1, file: /log, ssh_config: /dev/null}\n",
+		"notes.txt": "not a spec",
+	})
+
+	/// When
+	specData, err := LoadSpecFile(dir)
+
+	/// Then
+	require.NoError(t, err)
+	assert.Len(t, specData.Hosts, 2)
+}
+
+func TestLoadSpecFile_Conflicts(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected string
+	}{
+		"host": {
+			files: map[string]string{
+				"a.yml": "hosts:\n  web: {hostname: web-1, file: /log}\n",
+				"b.yml": "hosts:\n  web: {hostname: web-2, file: /log}\n",
+			},
+			expected: "host web is defined in both a.yml and b.yml",
+		},
+		"group": {
+			files: map[string]string{
+				"a.yml": "hosts:\n  web: {hostname: web-1, file: /log}\ngroups:\n  g: [web]\n",
+				"b.yml": "groups:\n  g: [web]\n",
+			},
+			expected: "group g is defined in both a.yml and b.yml",
+		},
+		"defaults": {
+			files: map[string]string{
+				"a.yml": "hosts:\n  web: {hostname: web-1, file: /log}\ndefaults: {port: 22}\n",
+				"b.yml": "defaults: {port: 2222}\n",
+			},
+			expected: "defaults are set in both a.yml and b.yml",
+		},
+		"missing include": {
+			files: map[string]string{
+				"a.yml": "include: [missing.yml]\nhosts:\n  web: {hostname: web-1, file: /log}\n",
+			},
+			expected: "missing.yml doesn't exist",
+		},
+	}
+	for name, tc := range tests {
+		/// Given
+		dir := writeSpecFiles(t, tc.files)
+
+		/// When
+		_, err := LoadSpecFile(dir)
+
+		/// Then
+		require.Error(t, err, name)
+		assert.Contains(t, relativeMessage(dir, err.Error()), tc.expected, name)
+	}
+}
+
+func TestLoadSpecFile_EmptyDirectory(t *testing.T) {
+	_, err := LoadSpecFile(t.TempDir())
+	assert.Error(t, err)
+}
+
+// relativeMessage makes the paths in an error message relative to the directory.
+func relativeMessage(dir string, message string) string {
+	return strings.ReplaceAll(message, dir+string(filepath.Separator), "")
+}
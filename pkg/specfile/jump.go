@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JumpSpec encapsulates the parameters for a jump host (bastion) used to reach a host. Parameters that are left out
+// are inherited from the host being reached.
+type JumpSpec struct {
+	Hostname       string `yaml:"hostname"`
+	Port           int    `yaml:"port"`
+	Username       string `yaml:"username"`
+	IdentityFile   string `yaml:"identity_file"`
+	Auth           string `yaml:"auth"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	HostKeyPolicy  string `yaml:"host_key_policy"`
+
+	// ExtraIdentityFiles are tried after IdentityFile, if it was taken from an ssh_config entry listing several.
+	ExtraIdentityFiles []string `yaml:"-"`
+
+	// proxyJump is set for jump hosts taken from a ProxyJump. Like with ssh, they don't inherit the username and
+	// identity file of the host being reached, but fall back to the defaults instead.
+	proxyJump bool
+}
+
+// UnmarshalYAML allows a jump host to be given in the short [user@]host[:port] form.
+func (j *JumpSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		hop, err := ParseJumpSpec(value.Value)
+		if err != nil {
+			return err
+		}
+
+		*j = *hop
+		return nil
+	}
+
+	type plain JumpSpec
+	return value.Decode((*plain)(j))
+}
+
+// String returns the jump host in the [user@]host[:port] form.
+func (j *JumpSpec) String() string {
+	hostPort := j.Hostname
+	if j.Port != 0 {
+		hostPort = net.JoinHostPort(j.Hostname, strconv.Itoa(j.Port))
+	}
+
+	if j.Username == "" {
+		return hostPort
+	}
+
+	return j.Username + "@" + hostPort
+}
+
+// ParseJumpSpec parses a jump host given as [user@]host[:port].
+func ParseJumpSpec(s string) (*JumpSpec, error) {
+	hop := &JumpSpec{}
+
+	hostPort := strings.TrimSpace(s)
+	if at := strings.LastIndex(hostPort, "@"); at >= 0 {
+		hop.Username = hostPort[:at]
+		hostPort = hostPort[at+1:]
+	}
+
+	hop.Hostname = hostPort
+	if strings.HasPrefix(hostPort, "[") || strings.Count(hostPort, ":") == 1 {
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host '%s': %w", s, err)
+		}
+
+		hop.Hostname = host
+		hop.Port, err = strconv.Atoi(port)
+		if err != nil || hop.Port <= 0 || hop.Port > 65535 {
+			return nil, fmt.Errorf("invalid port in jump host '%s'", s)
+		}
+	}
+
+	if hop.Hostname == "" {
+		return nil, fmt.Errorf("invalid jump host '%s': blank hostname", s)
+	}
+
+	return hop, nil
+}
+
+// ParseProxyJump parses a comma separated list of jump hosts, as used by the ProxyJump option of ssh. The jump hosts
+// don't inherit the username and identity file of the host they lead to.
+func ParseProxyJump(s string) ([]*JumpSpec, error) {
+	var hops []*JumpSpec
+	for _, part := range strings.Split(s, ",") {
+		hop, err := ParseJumpSpec(part)
+		if err != nil {
+			return nil, err
+		}
+
+		hop.proxyJump = true
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// resolveSshConfig fills in the parameters the jump host leaves out from its ssh_config entry.
+func (j *JumpSpec) resolveSshConfig(config *SshConfig) error {
+	resolved, err := config.Resolve(j.Hostname)
+	if err != nil {
+		return err
+	}
+
+	if resolved.HostName != "" {
+		j.Hostname = resolved.HostName
+	}
+
+	if j.Port == 0 {
+		j.Port = resolved.Port
+	}
+
+	if j.Username == "" {
+		j.Username = resolved.User
+	}
+
+	if j.IdentityFile == "" && resolved.IdentityFile != "" {
+		j.IdentityFile = resolved.IdentityFile
+		j.ExtraIdentityFiles = resolved.IdentityFiles[1:]
+	}
+
+	return nil
+}
+
+// validate checks the jump host for errors and inherits the parameters it leaves out from the host being reached.
+func (j *JumpSpec) validate(host *HostSpec) error {
+	if j.Hostname == "" {
+		return errors.New("cannot have a blank hostname")
+	}
+
+	if j.Port == 0 {
+		j.Port = DefaultSshPort
+	}
+
+	if j.Username == "" {
+		j.Username = host.Username
+		if j.proxyJump {
+			j.Username = defaultUsername()
+		}
+	}
+
+	if j.IdentityFile == "" {
+		j.IdentityFile = host.IdentityFile
+		j.ExtraIdentityFiles = host.ExtraIdentityFiles
+		if j.proxyJump {
+			j.IdentityFile = defaultIdentityFile()
+			j.ExtraIdentityFiles = nil
+		}
+	}
+
+	if j.Auth == "" {
+		j.Auth = host.Auth
+	}
+
+	if err := validateAuth(j.Auth); err != nil {
+		return err
+	}
+
+	if j.KnownHostsFile == "" {
+		j.KnownHostsFile = host.KnownHostsFile
+	}
+
+	if j.HostKeyPolicy == "" {
+		j.HostKeyPolicy = host.HostKeyPolicy
+	}
+
+	return validateHostKeyPolicy(j.HostKeyPolicy)
+}
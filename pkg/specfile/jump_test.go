@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseJumpSpec(t *testing.T) {
+	tests := map[string]JumpSpec{
+		"bastion":                 {Hostname: "bastion"},
+		" bastion ":               {Hostname: "bastion"},
+		"ops@bastion":             {Hostname: "bastion", Username: "ops"},
+		"bastion:2222":            {Hostname: "bastion", Port: 2222},
+		"ops@bastion:2222":        {Hostname: "bastion", Port: 2222, Username: "ops"},
+		"ops@example.com@bastion": {Hostname: "bastion", Username: "ops@example.com"},
+		"[::1]:2222":              {Hostname: "::1", Port: 2222},
+		"::1":                     {Hostname: "::1"},
+	}
+
+	for s, expected := range tests {
+		hop, err := ParseJumpSpec(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, *hop, s)
+	}
+}
+
+func TestParseJumpSpec_Invalid(t *testing.T) {
+	for _, s := range []string{"", "ops@", "bastion:", "bastion:port", "bastion:0", "bastion:65536", ":22", "[::1"} {
+		_, err := ParseJumpSpec(s)
+		assert.Error(t, err, "jump host %q should be rejected", s)
+	}
+}
+
+func TestParseProxyJump(t *testing.T) {
+	/// When
+	hops, err := ParseProxyJump("ops@outer:2222,inner")
+
+	/// Then
+	require.NoError(t, err)
+	require.Len(t, hops, 2)
+	assert.Equal(t, "ops@outer:2222", hops[0].String())
+	assert.Equal(t, "inner", hops[1].String())
+	assert.True(t, hops[0].proxyJump)
+
+	_, err = ParseProxyJump("outer,,inner")
+	assert.Error(t, err, "an empty jump host should be rejected")
+}
+
+func TestJumpSpec_ValidateInherits(t *testing.T) {
+	/// Given
+	host := &HostSpec{Hostname: "web", Port: 2200, Username: "deploy", IdentityFile: "/keys/deploy", File: "/var/log/syslog",
+		Auth: AuthFile, KnownHostsFile: "/known_hosts", HostKeyPolicy: HostKeyPolicyAcceptNew,
+		Jump: []*JumpSpec{{Hostname: "bastion"}, {Hostname: "inner", Username: "ops", HostKeyPolicy: HostKeyPolicyStrict}}}
+
+	/// When
+	err := host.Validate()
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, JumpSpec{Hostname: "bastion", Port: DefaultSshPort, Username: "deploy", IdentityFile: "/keys/deploy",
+		Auth: AuthFile, KnownHostsFile: "/known_hosts", HostKeyPolicy: HostKeyPolicyAcceptNew}, *host.Jump[0])
+	assert.Equal(t, "ops", host.Jump[1].Username, "values of the jump host should take precedence")
+	assert.Equal(t, HostKeyPolicyStrict, host.Jump[1].HostKeyPolicy, "values of the jump host should take precedence")
+}
+
+func TestJumpSpec_ValidateProxyJumpDoesNotInherit(t *testing.T) {
+	/// Given
+	hops, err := ParseProxyJump("bastion")
+	require.NoError(t, err)
+	host := &HostSpec{Hostname: "web", Username: "deploy", IdentityFile: "/keys/deploy", File: "/var/log/syslog", Jump: hops}
+
+	/// When
+	err = host.Validate()
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, defaultUsername(), host.Jump[0].Username, "a ProxyJump host should use the default username")
+	assert.Equal(t, defaultIdentityFile(), host.Jump[0].IdentityFile, "a ProxyJump host should use the default identity file")
+	assert.Equal(t, host.KnownHostsFile, host.Jump[0].KnownHostsFile)
+}
+
+func TestJumpSpec_ValidateInvalid(t *testing.T) {
+	tests := map[string]*JumpSpec{
+		"blank hostname":      {},
+		"unknown auth":        {Hostname: "bastion", Auth: "agnet"},
+		"unknown host policy": {Hostname: "bastion", HostKeyPolicy: "accept_new"},
+	}
+
+	for name, hop := range tests {
+		/// Given
+		host := &HostSpec{Hostname: "web", File: "/var/log/syslog", Jump: []*JumpSpec{hop}}
+
+		/// When
+		err := host.Validate()
+
+		/// Then
+		assert.Error(t, err, name)
+	}
+}
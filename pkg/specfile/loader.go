@@ -22,15 +22,40 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/user"
 	"path"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 const DefaultSshPort int = 22
 
+const (
+	// HostKeyPolicyStrict refuses to connect to hosts whose key is not already known.
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyAcceptNew adds unknown host keys to the known_hosts file, but still refuses changed keys.
+	HostKeyPolicyAcceptNew = "accept-new"
+)
+
+const (
+	// FollowName follows the file by name, so it's reopened when it's rotated or recreated (tail -F).
+	FollowName = "name"
+	// FollowDescriptor keeps following the file that was opened first, even after it's renamed (tail -f).
+	FollowDescriptor = "descriptor"
+)
+
+const (
+	// AuthAgent authenticates with the keys held by the ssh-agent on SSH_AUTH_SOCK.
+	AuthAgent = "agent"
+	// AuthFile authenticates with the private key in the identity file.
+	AuthFile = "file"
+	// AuthBoth tries the ssh-agent keys first, then the identity file.
+	AuthBoth = "both"
+)
+
 func defaultUsername() string {
 	u, err := user.Current()
 	if err != nil {
@@ -44,18 +69,131 @@ func defaultUsername() string {
 	return split[len(split)-1]
 }
 
+// homeDir returns the home directory of the current user. Like a shell expanding ~, it's taken from $HOME if it's set.
+func homeDir() (string, error) {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return u.HomeDir, nil
+}
+
 func defaultIdentityFile() string {
-	u, _ := user.Current()
-	return path.Join(u.HomeDir, ".ssh", "id_rsa")
+	home, _ := homeDir()
+	return path.Join(home, ".ssh", "id_rsa")
+}
+
+func defaultKnownHostsFile() string {
+	home, _ := homeDir()
+	return path.Join(home, ".ssh", "known_hosts")
 }
 
 // HostSpec encapsulates the parameters for a single host to tail.
 type HostSpec struct {
-	Hostname     string `yaml:"hostname"`
-	Port         int    `yaml:"port"`
-	Username     string `yaml:"username"`
-	IdentityFile string `yaml:"identity_file"`
-	File         string `yaml:"file"`
+	Hostname       string `yaml:"hostname"`
+	Port           int    `yaml:"port"`
+	Username       string `yaml:"username"`
+	IdentityFile   string `yaml:"identity_file"`
+	Auth           string `yaml:"auth"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	HostKeyPolicy  string `yaml:"host_key_policy"`
+	SshConfig      string `yaml:"ssh_config"`
+	// Jump lists the jump hosts to tunnel through, in order, before reaching the host.
+	Jump []*JumpSpec `yaml:"jump"`
+	// File is a shorthand for tailing a single file, use Files to tail several files over the same connection.
+	File  string      `yaml:"file"`
+	Files []*FileSpec `yaml:"files"`
+	// Follow is either FollowName (the default) or FollowDescriptor.
+	Follow string `yaml:"follow"`
+	// Labels describe the host, so it can be selected by a Selector.
+	Labels map[string]string `yaml:"labels"`
+	// Color overrides the color the host tag is shown in, which is otherwise picked from the tag.
+	Color string `yaml:"color"`
+	// Lines is the number of lines shown from the end of each file when tailing starts, defaulting to 10.
+	Lines     *int          `yaml:"lines"`
+	Timestamp TimestampSpec `yaml:"timestamp"`
+	// Filters selects the lines of the host to show.
+	Filters FilterSpec `yaml:"filters"`
+	// RemoteFilter selects the lines of the host to show like Filters, but is run by grep on the host, so the other
+	// lines aren't sent over the connection at all.
+	RemoteFilter FilterSpec `yaml:"remote_filter"`
+	// Multiline groups lines belonging together into a single record.
+	Multiline MultilineSpec `yaml:"multiline"`
+	// KeepaliveInterval is how often the connection is checked, a connection that doesn't answer is reconnected.
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval"`
+	Reconnect         ReconnectSpec `yaml:"reconnect"`
+
+	// ExtraIdentityFiles are tried after IdentityFile, if it was taken from an ssh_config entry listing several.
+	ExtraIdentityFiles []string `yaml:"-"`
+}
+
+// TailFiles returns the files to tail on the host, including the one given with the File shorthand.
+func (h *HostSpec) TailFiles() []*FileSpec {
+	if h.File == "" {
+		return h.Files
+	}
+
+	file := &FileSpec{Path: h.File, defaultTag: true}
+	file.Tag = file.DefaultTag()
+	return append([]*FileSpec{file}, h.Files...)
+}
+
+// resolveSshConfig fills in the connection parameters the spec leaves out from the ssh_config entry matching the
+// hostname, or the host tag if no hostname is given. Values set in the spec take precedence.
+func (h *HostSpec) resolveSshConfig(hostTag string, config *SshConfig) error {
+	alias := h.Hostname
+	if alias == "" {
+		alias = hostTag
+	}
+
+	resolved, err := config.Resolve(alias)
+	if err != nil {
+		return err
+	}
+
+	// Like ssh, the alias itself is connected to if neither the spec nor the config gives a hostname.
+	if resolved.HostName != "" {
+		h.Hostname = resolved.HostName
+	} else if h.Hostname == "" {
+		h.Hostname = alias
+	}
+
+	if h.Port == 0 {
+		h.Port = resolved.Port
+	}
+
+	if h.Username == "" {
+		h.Username = resolved.User
+	}
+
+	if h.IdentityFile == "" && resolved.IdentityFile != "" {
+		h.IdentityFile = resolved.IdentityFile
+		h.ExtraIdentityFiles = resolved.IdentityFiles[1:]
+	}
+
+	if h.Jump == nil && resolved.ProxyJump != "" {
+		h.Jump, err = ParseProxyJump(resolved.ProxyJump)
+		if err != nil {
+			return fmt.Errorf("invalid ProxyJump for %s: %w", alias, err)
+		}
+	}
+
+	for _, hop := range h.Jump {
+		if hop == nil {
+			continue
+		}
+
+		if err = hop.resolveSshConfig(config); err != nil {
+			return fmt.Errorf("jump host %s: %w", hop, err)
+		}
+	}
+
+	return nil
 }
 
 // Validate checks the HostSpec for errors and sets reasonable defaults.
@@ -76,16 +214,145 @@ func (h *HostSpec) Validate() error {
 		h.IdentityFile = defaultIdentityFile()
 	}
 
-	if h.File == "" {
+	if h.Auth == "" {
+		h.Auth = AuthBoth
+	}
+
+	if err := validateAuth(h.Auth); err != nil {
+		return err
+	}
+
+	if h.KnownHostsFile == "" {
+		h.KnownHostsFile = defaultKnownHostsFile()
+	}
+
+	if h.HostKeyPolicy == "" {
+		h.HostKeyPolicy = HostKeyPolicyStrict
+	}
+
+	if err := validateHostKeyPolicy(h.HostKeyPolicy); err != nil {
+		return err
+	}
+
+	for i, hop := range h.Jump {
+		if hop == nil {
+			return fmt.Errorf("jump host %d is empty", i+1)
+		}
+
+		if err := hop.validate(h); err != nil {
+			return fmt.Errorf("jump host %d: %w", i+1, err)
+		}
+	}
+
+	if h.KeepaliveInterval < 0 {
+		return errors.New("keepalive interval cannot be negative")
+	}
+
+	if h.KeepaliveInterval == 0 {
+		h.KeepaliveInterval = DefaultKeepaliveInterval
+	}
+
+	if err := h.Reconnect.Validate(); err != nil {
+		return fmt.Errorf("reconnect: %w", err)
+	}
+
+	if h.File != "" {
+		if len(h.Files) > 0 {
+			return errors.New("cannot have both file and files")
+		}
+		h.Files = []*FileSpec{{Path: h.File}}
+		h.File = ""
+	}
+
+	if len(h.Files) == 0 {
 		return errors.New("cannot have a blank file")
 	}
 
+	if h.Lines != nil && *h.Lines < 0 {
+		return errors.New("lines cannot be negative")
+	}
+
+	if err := h.Timestamp.Validate(); err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+
+	if err := h.Multiline.Validate(); err != nil {
+		return fmt.Errorf("multiline: %w", err)
+	}
+
+	if err := h.Filters.Validate(); err != nil {
+		return fmt.Errorf("filters: %w", err)
+	}
+
+	if err := h.RemoteFilter.Validate(); err != nil {
+		return fmt.Errorf("remote filter: %w", err)
+	}
+
+	switch h.Follow {
+	case "":
+		h.Follow = FollowName
+	case FollowName, FollowDescriptor:
+	default:
+		return fmt.Errorf("unknown follow mode '%s', must be one of %s or %s", h.Follow, FollowName, FollowDescriptor)
+	}
+
+	if h.Color != "" && !ValidColor(h.Color) {
+		return fmt.Errorf("unknown color '%s', must be one of %s", h.Color, strings.Join(Colors, ", "))
+	}
+
+	tags := map[string]bool{}
+	for i, f := range h.Files {
+		if f == nil || f.Path == "" {
+			return fmt.Errorf("file %d: cannot have a blank path", i+1)
+		}
+
+		if strings.ContainsAny(f.Path, "\x00\n\r") {
+			return fmt.Errorf("file %d: path %q cannot contain NUL or newline characters", i+1, f.Path)
+		}
+
+		if f.Tag == "" {
+			f.Tag = f.DefaultTag()
+			f.defaultTag = true
+		}
+
+		if tags[f.Tag] {
+			return fmt.Errorf("file %s: tag '%s' is used more than once, set a distinct tag for each file", f.Path, f.Tag)
+		}
+		tags[f.Tag] = true
+	}
+
 	return nil
 }
 
+func validateAuth(auth string) error {
+	switch auth {
+	case AuthAgent, AuthFile, AuthBoth:
+		return nil
+	default:
+		return fmt.Errorf("unknown auth '%s', must be one of %s, %s or %s", auth, AuthAgent, AuthFile, AuthBoth)
+	}
+}
+
+func validateHostKeyPolicy(policy string) error {
+	switch policy {
+	case HostKeyPolicyStrict, HostKeyPolicyAcceptNew:
+		return nil
+	default:
+		return fmt.Errorf("unknown host key policy '%s', must be one of %s or %s", policy, HostKeyPolicyStrict, HostKeyPolicyAcceptNew)
+	}
+}
+
 // SpecData encapsulates runtime parameters for SSH tailing.
 type SpecData struct {
-	Hosts map[string]*HostSpec `yaml:"hosts"`
+	// Include lists spec files or glob patterns of spec files to merge into this one, relative to its directory.
+	Include []string `yaml:"include"`
+	// SshConfig is the ssh_config file used to resolve hosts, defaulting to ~/.ssh/config.
+	SshConfig string               `yaml:"ssh_config"`
+	Hosts     map[string]*HostSpec `yaml:"hosts"`
+	// Defaults are inherited by every host for the parameters it leaves out, after the defaults of its groups.
+	Defaults *HostSpec `yaml:"defaults"`
+	// Groups are named sets of hosts, so they can be selected together.
+	Groups map[string]*GroupSpec `yaml:"groups"`
 }
 
 // Validate checks the SpecData for errors and sets reasonable defaults.
@@ -94,7 +361,33 @@ func (s *SpecData) Validate() error {
 		return errors.New("hosts must have at least one definition")
 	}
 
+	if err := s.validateGroups(); err != nil {
+		return err
+	}
+
+	if err := s.applyDefaults(); err != nil {
+		return err
+	}
+
+	sshConfigs := sshConfigCache{}
 	for k, v := range s.Hosts {
+		sshConfigFile := v.SshConfig
+		if sshConfigFile == "" {
+			sshConfigFile = s.SshConfig
+		}
+		if sshConfigFile == "" {
+			sshConfigFile = defaultSshConfigFile()
+		}
+
+		sshConfig, err := sshConfigs.load(sshConfigFile)
+		if err != nil {
+			return fmt.Errorf("host spec %s: %w", k, err)
+		}
+
+		if err := v.resolveSshConfig(k, sshConfig); err != nil {
+			return fmt.Errorf("host spec %s: %w", k, err)
+		}
+
 		if err := v.Validate(); err != nil {
 			return fmt.Errorf("host spec %s: %w", k, err)
 		}
@@ -103,7 +396,8 @@ func (s *SpecData) Validate() error {
 	return nil
 }
 
-// LoadSpecData reads the SpecData and validates it.
+// LoadSpecData reads the SpecData, expands environment variables and ~ in it, and validates it. Included files are
+// looked up relative to the working directory, use LoadSpecFile to load them relative to the spec file.
 func LoadSpecData(reader io.Reader) (*SpecData, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
@@ -115,7 +409,24 @@ func LoadSpecData(reader io.Reader) (*SpecData, error) {
 		return nil, fmt.Errorf("invalid spec data format: %w", err)
 	}
 
-	if err = specData.Validate(); err != nil {
+	if len(specData.Include) > 0 {
+		l := newSpecLoader()
+		if err = l.add(specData, "spec data", "."); err != nil {
+			return nil, err
+		}
+		specData = l.merged
+	}
+
+	return finishSpecData(specData)
+}
+
+// finishSpecData expands and validates spec data once all of it is loaded.
+func finishSpecData(specData *SpecData) (*SpecData, error) {
+	if err := specData.expand(); err != nil {
+		return nil, fmt.Errorf("invalid spec data: %w", err)
+	}
+
+	if err := specData.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid spec data: %w", err)
 	}
 
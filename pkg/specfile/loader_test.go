@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHostSpec_ValidateHostilePaths(t *testing.T) {
+	for _, file := range []string{"/var/log/a\nrm -rf /", "/var/log/a\x00b", "/var/log/a\r"} {
+		/// Given
+		host := &HostSpec{Hostname: "host", File: file}
+
+		/// When
+		err := host.Validate()
+
+		/// Then
+		assert.Error(t, err, "path %q should be rejected", file)
+	}
+}
+
+func TestHostSpec_ValidateColor(t *testing.T) {
+	host := &HostSpec{Hostname: "localhost", File: "/var/log/syslog", Color: "bright-cyan"}
+	assert.NoError(t, host.Validate())
+
+	host = &HostSpec{Hostname: "localhost", File: "/var/log/syslog", Color: "purple"}
+	assert.Error(t, host.Validate())
+}
+
+func TestMultilineSpec_Validate(t *testing.T) {
+	multiline := MultilineSpec{Start: `^\S`}
+	require.NoError(t, multiline.Validate())
+	assert.Equal(t, DefaultMultilineMaxLines, multiline.MaxLines)
+	assert.Equal(t, DefaultMultilineFlushTimeout, multiline.FlushTimeout)
+	assert.True(t, multiline.StartsRecord([]byte("Exception")))
+	assert.False(t, multiline.StartsRecord([]byte("  at Main")))
+
+	multiline = MultilineSpec{Start: `^\S`, Continuation: `^\s`}
+	assert.Error(t, multiline.Validate())
+}
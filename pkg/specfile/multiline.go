@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultMultilineMaxLines caps the lines grouped into a single record.
+	DefaultMultilineMaxLines = 500
+	// DefaultMultilineFlushTimeout is how long a record waits for more lines before it's passed on.
+	DefaultMultilineFlushTimeout = time.Second
+)
+
+// MultilineSpec groups lines that belong together, like the lines of a stack trace, into a single record. Records are
+// told apart either by the lines starting them, or by the lines continuing them.
+type MultilineSpec struct {
+	// Start matches the first line of a record, every line not matching it continues the record before it.
+	Start string `yaml:"start"`
+	// Continuation matches the lines continuing a record, every line not matching it starts a new record.
+	Continuation string `yaml:"continuation"`
+	// MaxLines is the most lines in a record, a record reaching it is passed on right away.
+	MaxLines int `yaml:"max_lines"`
+	// FlushTimeout is how long a record waits for more lines, since its end is only known once the next one starts.
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	section
+}
+
+// UnmarshalYAML marks multiline as given, so multiline: {} keeps every line on its own even if the defaults group them.
+func (m *MultilineSpec) UnmarshalYAML(value *yaml.Node) error {
+	type plain MultilineSpec
+	return m.decode(value, (*plain)(m))
+}
+
+// Configured returns true if lines are grouped into records.
+func (m *MultilineSpec) Configured() bool {
+	return m.Start != "" || m.Continuation != ""
+}
+
+// Validate checks the MultilineSpec for errors and sets reasonable defaults.
+func (m *MultilineSpec) Validate() error {
+	if !m.Configured() {
+		return nil
+	}
+
+	if m.Start != "" && m.Continuation != "" {
+		return errors.New("cannot have both start and continuation")
+	}
+
+	if m.MaxLines < 0 || m.FlushTimeout < 0 {
+		return errors.New("max lines and flush timeout cannot be negative")
+	}
+
+	if m.MaxLines == 0 {
+		m.MaxLines = DefaultMultilineMaxLines
+	}
+
+	if m.FlushTimeout == 0 {
+		m.FlushTimeout = DefaultMultilineFlushTimeout
+	}
+
+	var err error
+	if m.Start != "" {
+		if m.start, err = regexp.Compile(m.Start); err != nil {
+			return fmt.Errorf("invalid start pattern: %w", err)
+		}
+	} else {
+		if m.continuation, err = regexp.Compile(m.Continuation); err != nil {
+			return fmt.Errorf("invalid continuation pattern: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// StartsRecord returns true if the line starts a new record instead of continuing the one before it. Every line starts
+// a record before the MultilineSpec is validated.
+func (m *MultilineSpec) StartsRecord(line []byte) bool {
+	switch {
+	case m.start != nil:
+		return m.start.Match(line)
+	case m.continuation != nil:
+		return !m.continuation.Match(line)
+	default:
+		return true
+	}
+}
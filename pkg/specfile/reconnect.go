@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultInitialBackoff is the delay before the first reconnect attempt.
+	DefaultInitialBackoff = time.Second
+	// DefaultMaxBackoff caps the delay between reconnect attempts.
+	DefaultMaxBackoff = time.Minute
+	// DefaultKeepaliveInterval is how often a connection is checked for being alive.
+	DefaultKeepaliveInterval = 15 * time.Second
+)
+
+// ReconnectSpec configures how a host is reconnected after its connection drops. The delay between attempts doubles
+// after every failed attempt, up to MaxBackoff.
+type ReconnectSpec struct {
+	// MaxRetries is the number of consecutive failed attempts before giving up on the host, or on a file whose tail
+	// keeps exiting. Reconnects that drop again soon count as failed. Zero, the default, retries forever, and a
+	// negative number disables reconnecting.
+	MaxRetries     *int          `yaml:"max_retries"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// Retries returns the number of consecutive failed attempts before giving up on the host, which is zero if it should
+// be retried forever.
+func (r *ReconnectSpec) Retries() int {
+	if r.MaxRetries == nil || *r.MaxRetries < 0 {
+		return 0
+	}
+
+	return *r.MaxRetries
+}
+
+// Enabled returns true if the host should be reconnected at all.
+func (r *ReconnectSpec) Enabled() bool {
+	return r.MaxRetries == nil || *r.MaxRetries >= 0
+}
+
+// Validate checks the ReconnectSpec for errors and sets reasonable defaults.
+func (r *ReconnectSpec) Validate() error {
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("backoff cannot be negative")
+	}
+
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = DefaultInitialBackoff
+	}
+
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if r.MaxBackoff < r.InitialBackoff {
+		r.MaxBackoff = r.InitialBackoff
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReconnectSpec_Validate(t *testing.T) {
+	reconnect := ReconnectSpec{}
+	require.NoError(t, reconnect.Validate())
+	assert.Equal(t, DefaultInitialBackoff, reconnect.InitialBackoff)
+	assert.Equal(t, DefaultMaxBackoff, reconnect.MaxBackoff)
+	assert.True(t, reconnect.Enabled(), "zero retries should retry forever")
+
+	reconnect = ReconnectSpec{InitialBackoff: 2 * time.Minute}
+	require.NoError(t, reconnect.Validate())
+	assert.Equal(t, 2*time.Minute, reconnect.MaxBackoff, "the maximum backoff should not be below the initial one")
+
+	never, forever, five := -1, 0, 5
+	reconnect = ReconnectSpec{MaxRetries: &never}
+	require.NoError(t, reconnect.Validate())
+	assert.False(t, reconnect.Enabled(), "negative retries should disable reconnecting")
+	assert.Equal(t, 0, reconnect.Retries())
+
+	reconnect = ReconnectSpec{MaxRetries: &forever}
+	assert.True(t, reconnect.Enabled())
+	assert.Equal(t, 0, reconnect.Retries(), "zero retries should retry forever")
+
+	reconnect = ReconnectSpec{MaxRetries: &five}
+	assert.True(t, reconnect.Enabled())
+	assert.Equal(t, 5, reconnect.Retries())
+
+	reconnect = ReconnectSpec{InitialBackoff: -time.Second}
+	assert.Error(t, reconnect.Validate())
+}
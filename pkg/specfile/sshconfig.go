@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SshConfig holds the Host blocks of an OpenSSH client configuration file, including those of the files it includes.
+type SshConfig struct {
+	blocks []*sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string]string
+	// identityFiles holds every IdentityFile of the block, since ssh tries all of them instead of only the first.
+	identityFiles []string
+	// enclosing is the block an included file was included from, the blocks of the file only apply if it does.
+	enclosing *sshConfigBlock
+}
+
+// SshConfigHost is the result of resolving a host alias through an SshConfig.
+type SshConfigHost struct {
+	HostName string
+	Port     int
+	User     string
+	// IdentityFile is the first of the IdentityFiles, which are all tried in order.
+	IdentityFile  string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// sshConfigKeywords are the keywords sshtail uses, all other keywords are ignored.
+var sshConfigKeywords = map[string]bool{
+	"hostname":     true,
+	"port":         true,
+	"user":         true,
+	"identityfile": true,
+	"proxyjump":    true,
+}
+
+// maxSshConfigIncludeDepth limits how deeply files can be included, like ssh does, so include cycles are caught.
+const maxSshConfigIncludeDepth = 16
+
+func defaultSshConfigFile() string {
+	home, _ := homeDir()
+	return path.Join(home, ".ssh", "config")
+}
+
+// LoadSshConfig reads an ssh_config file.
+func LoadSshConfig(filename string) (*SshConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := ParseSshConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh_config file %s: %w", filename, err)
+	}
+
+	return config, nil
+}
+
+// ParseSshConfig parses ssh_config content. Options that appear before the first Host line apply to every host, and
+// Match blocks are skipped since their criteria can't be evaluated here. Like ssh, included files are relative to
+// ~/.ssh unless their path is absolute.
+func ParseSshConfig(reader io.Reader) (*SshConfig, error) {
+	config := &SshConfig{}
+	if err := config.parse(reader, nil, 0); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parse adds the blocks of ssh_config content to the config. The content of an included file starts out in the block
+// it was included from, and its own blocks only apply if that one does.
+func (c *SshConfig) parse(reader io.Reader, enclosing *sshConfigBlock, depth int) error {
+	current := enclosing
+	if current == nil {
+		current = &sshConfigBlock{patterns: []string{"*"}, options: map[string]string{}}
+	}
+	c.blocks = append(c.blocks, current)
+
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		keyword, value, err := splitSshConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			patterns := strings.Fields(strings.ToLower(value))
+			if len(patterns) == 0 {
+				return fmt.Errorf("line %d: Host requires at least one pattern", lineNum)
+			}
+			current = &sshConfigBlock{patterns: patterns, options: map[string]string{}, enclosing: enclosing}
+			c.blocks = append(c.blocks, current)
+		case "match":
+			current = &sshConfigBlock{options: map[string]string{}, enclosing: enclosing}
+			c.blocks = append(c.blocks, current)
+		case "include":
+			if err := c.include(value, current, depth); err != nil {
+				return fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			// Options after the Include come after those of the included files, so they continue in a new block.
+			current = &sshConfigBlock{patterns: current.patterns, options: map[string]string{}, enclosing: current.enclosing}
+			c.blocks = append(c.blocks, current)
+		case "identityfile":
+			current.identityFiles = append(current.identityFiles, value)
+		default:
+			if !sshConfigKeywords[keyword] {
+				continue
+			}
+			// The first value given for a keyword wins, like in ssh.
+			if _, ok := current.options[keyword]; !ok {
+				current.options[keyword] = value
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// include parses the files matching the glob patterns of an Include line into the block it appears in.
+func (c *SshConfig) include(value string, block *sshConfigBlock, depth int) error {
+	if depth >= maxSshConfigIncludeDepth {
+		return fmt.Errorf("too many nested includes at Include %s", value)
+	}
+
+	for _, pattern := range strings.Fields(value) {
+		pattern = expandHome(pattern)
+		if !path.IsAbs(pattern) {
+			home, err := homeDir()
+			if err != nil {
+				return fmt.Errorf("failed to resolve Include %s: %w", pattern, err)
+			}
+			pattern = path.Join(home, ".ssh", pattern)
+		}
+
+		filenames, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid Include pattern %s: %w", pattern, err)
+		}
+
+		for _, filename := range filenames {
+			if err := c.includeFile(filename, block, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *SshConfig) includeFile(filename string, block *sshConfigBlock, depth int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read included file: %w", err)
+	}
+	defer f.Close()
+
+	if err := c.parse(f, block, depth+1); err != nil {
+		return fmt.Errorf("included file %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// splitSshConfigLine returns the lower cased keyword and the unquoted value of a line, or an empty keyword for blank
+// lines and comments.
+func splitSshConfigLine(line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", "", fmt.Errorf("missing value for %s", line)
+	}
+
+	keyword := strings.ToLower(line[:end])
+	value := strings.TrimSpace(line[end:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	if value == "" {
+		return "", "", fmt.Errorf("missing value for %s", line[:end])
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return "", "", fmt.Errorf("unterminated quote in value for %s", line[:end])
+		}
+		value = value[1 : len(value)-1]
+	}
+
+	return keyword, value, nil
+}
+
+// Resolve looks up the options that apply to a host alias. Like ssh, the first value found for an option wins.
+func (c *SshConfig) Resolve(alias string) (*SshConfigHost, error) {
+	options := map[string]string{}
+	var identityFiles []string
+	for _, block := range c.blocks {
+		if !block.matches(alias) {
+			continue
+		}
+
+		for keyword, value := range block.options {
+			if _, ok := options[keyword]; !ok {
+				options[keyword] = value
+			}
+		}
+		identityFiles = append(identityFiles, block.identityFiles...)
+	}
+
+	resolved := &SshConfigHost{
+		User:      options["user"],
+		ProxyJump: options["proxyjump"],
+	}
+
+	if hostName, ok := options["hostname"]; ok {
+		resolved.HostName = expandSshConfigTokens(hostName, alias)
+	}
+
+	if port, ok := options["port"]; ok {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("invalid Port '%s' for %s", port, alias)
+		}
+		resolved.Port = p
+	}
+
+	seen := map[string]bool{}
+	for _, identityFile := range identityFiles {
+		if strings.ToLower(identityFile) == "none" {
+			continue
+		}
+
+		identityFile = expandSshConfigPath(expandSshConfigTokens(identityFile, alias))
+		if !seen[identityFile] {
+			seen[identityFile] = true
+			resolved.IdentityFiles = append(resolved.IdentityFiles, identityFile)
+		}
+	}
+	if len(resolved.IdentityFiles) > 0 {
+		resolved.IdentityFile = resolved.IdentityFiles[0]
+	}
+
+	if strings.ToLower(resolved.ProxyJump) == "none" {
+		resolved.ProxyJump = ""
+	}
+
+	return resolved, nil
+}
+
+func (b *sshConfigBlock) matches(alias string) bool {
+	if b.enclosing != nil && !b.enclosing.matches(alias) {
+		return false
+	}
+
+	alias = strings.ToLower(alias)
+	matched := false
+	for _, pattern := range b.patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchSshPattern(pattern[1:], alias) {
+				return false
+			}
+			continue
+		}
+
+		if matchSshPattern(pattern, alias) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// matchSshPattern matches a string against an ssh_config pattern, where '*' matches any sequence of characters and
+// '?' matches exactly one character.
+func matchSshPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchSshPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// expandSshConfigTokens replaces the %h and %% tokens.
+func expandSshConfigTokens(value, alias string) string {
+	return strings.NewReplacer("%h", alias, "%%", "%").Replace(value)
+}
+
+// expandSshConfigPath expands a leading ~ and the %d token to the user's home directory.
+func expandSshConfigPath(value string) string {
+	home, err := homeDir()
+	if err != nil {
+		return value
+	}
+
+	return strings.ReplaceAll(expandHome(value), "%d", home)
+}
+
+// sshConfigCache loads every ssh_config file at most once while validating a spec.
+type sshConfigCache map[string]*SshConfig
+
+func (c sshConfigCache) load(filename string) (*SshConfig, error) {
+	if config, ok := c[filename]; ok {
+		return config, nil
+	}
+
+	config, err := LoadSshConfig(filename)
+	if errors.Is(err, os.ErrNotExist) && filename == defaultSshConfigFile() {
+		// Not having a personal ssh_config is perfectly normal.
+		config, err = &SshConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c[filename] = config
+	return config, nil
+}
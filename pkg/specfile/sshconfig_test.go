@@ -0,0 +1,271 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testSshConfig = `
+Host web-*  !web-canary
+    User deploy
+    Port 2200
+
+Host web-1
+    HostName 10.0.0.1
+    User ignored
+
+Host db?
+    HostName %h.internal
+    IdentityFile=/keys/db_key
+
+Host web1
+    User deploy
+
+Host bastioned
+    HostName 10.0.1.1
+    ProxyJump jump.example.com
+
+Match host foo
+    User matched
+
+Host *
+    User fallback
+    IdentityFile ~/.ssh/id_global
+`
+
+func parseTestSshConfig(t *testing.T) *SshConfig {
+	config, err := ParseSshConfig(strings.NewReader(testSshConfig))
+	require.NoError(t, err, "failed to parse ssh_config")
+	return config
+}
+
+func TestSshConfig_Resolve(t *testing.T) {
+	config := parseTestSshConfig(t)
+
+	tests := map[string]SshConfigHost{
+		"web-1":      {HostName: "10.0.0.1", User: "deploy", Port: 2200},
+		"web-canary": {User: "fallback"},
+		"db1":        {HostName: "db1.internal", User: "fallback", IdentityFile: "/keys/db_key"},
+		"dbx2":       {User: "fallback"},
+		"foo":        {User: "fallback"},
+	}
+
+	for alias, expected := range tests {
+		resolved, err := config.Resolve(alias)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.HostName, resolved.HostName, "HostName of %s", alias)
+		assert.Equal(t, expected.User, resolved.User, "User of %s", alias)
+		assert.Equal(t, expected.Port, resolved.Port, "Port of %s", alias)
+		if expected.IdentityFile != "" {
+			assert.Equal(t, expected.IdentityFile, resolved.IdentityFile, "IdentityFile of %s", alias)
+		} else {
+			assert.True(t, strings.HasSuffix(resolved.IdentityFile, "/.ssh/id_global"), "IdentityFile of %s", alias)
+		}
+	}
+}
+
+func TestHostSpec_ResolveSshConfig(t *testing.T) {
+	config := parseTestSshConfig(t)
+
+	/// Given
+	host := &HostSpec{Hostname: "web-1", Port: 22, File: "/var/log/syslog"}
+
+	/// When
+	err := host.resolveSshConfig("web", config)
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", host.Hostname)
+	assert.Equal(t, 22, host.Port, "spec values should take precedence")
+	assert.Equal(t, "deploy", host.Username)
+}
+
+func TestHostSpec_ResolveSshConfigByTag(t *testing.T) {
+	config := parseTestSshConfig(t)
+
+	/// Given
+	host := &HostSpec{File: "/var/log/syslog"}
+
+	/// When
+	err := host.resolveSshConfig("db7", config)
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, "db7.internal", host.Hostname)
+	assert.Equal(t, "/keys/db_key", host.IdentityFile)
+
+	/// Given
+	host = &HostSpec{File: "/var/log/syslog"}
+
+	/// When
+	err = host.resolveSshConfig("web1", config)
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, "web1", host.Hostname, "the tag should be the hostname if the config has no HostName")
+	assert.Equal(t, "deploy", host.Username)
+	assert.NoError(t, host.Validate())
+}
+
+func TestSshConfig_ResolveEveryIdentityFile(t *testing.T) {
+	/// Given
+	config, err := ParseSshConfig(strings.NewReader(`
+Host web-*
+    IdentityFile /keys/web_key
+    IdentityFile /keys/deploy_key
+
+Host *
+    IdentityFile /keys/deploy_key
+    IdentityFile /keys/default_key
+`))
+	require.NoError(t, err)
+
+	/// When
+	resolved, err := config.Resolve("web-1")
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, "/keys/web_key", resolved.IdentityFile)
+	assert.Equal(t, []string{"/keys/web_key", "/keys/deploy_key", "/keys/default_key"}, resolved.IdentityFiles)
+
+	/// When
+	host := &HostSpec{Hostname: "web-1", File: "/var/log/syslog"}
+	require.NoError(t, host.resolveSshConfig("web", config))
+
+	/// Then
+	assert.Equal(t, "/keys/web_key", host.IdentityFile)
+	assert.Equal(t, []string{"/keys/deploy_key", "/keys/default_key"}, host.ExtraIdentityFiles)
+}
+
+func TestLoadSshConfig_Include(t *testing.T) {
+	/// Given
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.MkdirAll(path.Join(home, ".ssh", "config.d"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(home, ".ssh", "config.d", "web.conf"), []byte(`
+Host web-1
+    HostName 10.0.0.1
+    Port 2200
+`), 0600))
+	require.NoError(t, os.WriteFile(path.Join(home, ".ssh", "config.d", "db.conf"), []byte(`
+User dba
+
+Host db-1
+    HostName 10.0.2.1
+`), 0600))
+	require.NoError(t, os.WriteFile(path.Join(home, "scoped.conf"), []byte(`
+User scoped
+`), 0600))
+
+	configFile := path.Join(t.TempDir(), "ssh_config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+Include config.d/*.conf
+
+Host db-*
+    Include ~/scoped.conf
+
+Host *
+    User fallback
+`), 0600))
+
+	/// When
+	config, err := LoadSshConfig(configFile)
+	require.NoError(t, err)
+	web, err := config.Resolve("web-1")
+	require.NoError(t, err)
+	db, err := config.Resolve("db-1")
+	require.NoError(t, err)
+
+	/// Then
+	assert.Equal(t, "10.0.0.1", web.HostName, "hosts of included files should be resolved")
+	assert.Equal(t, 2200, web.Port)
+	assert.Equal(t, "dba", web.User, "options before the first Host of an included file apply where it's included")
+	assert.Equal(t, "10.0.2.1", db.HostName)
+	assert.Equal(t, "dba", db.User)
+
+	/// When
+	other, err := config.Resolve("other")
+	require.NoError(t, err)
+
+	/// Then
+	assert.Equal(t, "dba", other.User)
+}
+
+func TestLoadSshConfig_IncludeScopedToHost(t *testing.T) {
+	/// Given
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.MkdirAll(path.Join(home, ".ssh"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(home, ".ssh", "db.conf"), []byte(`
+User dba
+
+Host *
+    Port 2200
+`), 0600))
+
+	configFile := path.Join(t.TempDir(), "ssh_config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+Host db-*
+    Include db.conf
+    User ignored
+
+Host *
+    User fallback
+`), 0600))
+
+	/// When
+	config, err := LoadSshConfig(configFile)
+	require.NoError(t, err)
+	db, err := config.Resolve("db-1")
+	require.NoError(t, err)
+	web, err := config.Resolve("web-1")
+	require.NoError(t, err)
+
+	/// Then
+	assert.Equal(t, "dba", db.User, "the included file should apply to the Host it's included in")
+	assert.Equal(t, 2200, db.Port)
+	assert.Equal(t, "fallback", web.User, "the included file should not apply to other hosts")
+	assert.Equal(t, 0, web.Port)
+}
+
+func TestLoadSshConfig_IncludeCycle(t *testing.T) {
+	/// Given
+	configFile := path.Join(t.TempDir(), "ssh_config")
+	require.NoError(t, os.WriteFile(configFile, []byte("Include "+configFile+"\n"), 0600))
+
+	/// When
+	_, err := LoadSshConfig(configFile)
+
+	/// Then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many nested includes")
+}
+
+func TestParseSshConfig_Invalid(t *testing.T) {
+	for _, content := range []string{"Host", "User \"unterminated", "Host\t\n"} {
+		_, err := ParseSshConfig(strings.NewReader(content))
+		assert.Error(t, err, "content %q should be rejected", content)
+	}
+}
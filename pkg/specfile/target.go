@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseTailTarget parses a file to tail given as [user@]host[:port]:path, like the remote files of scp. The host may be
+// an IPv6 address in brackets.
+func ParseTailTarget(s string) (*HostSpec, error) {
+	address, path, err := splitTailTarget(s)
+	if err != nil {
+		return nil, err
+	}
+
+	hop, err := ParseJumpSpec(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host in '%s': %w", s, err)
+	}
+
+	return &HostSpec{
+		Hostname: hop.Hostname,
+		Port:     hop.Port,
+		Username: hop.Username,
+		Files:    []*FileSpec{{Path: path}},
+	}, nil
+}
+
+// splitTailTarget splits a target into the [user@]host[:port] address and the path.
+func splitTailTarget(s string) (string, string, error) {
+	// The path may contain an @ as well, but the user is only found before the host.
+	user, rest := "", s
+	if at := strings.Index(rest, "@"); at >= 0 && !strings.Contains(rest[:at], ":") {
+		user, rest = rest[:at+1], rest[at+1:]
+	}
+
+	host := rest
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("invalid target '%s': missing ]", s)
+		}
+		host, rest = rest[:end+1], rest[end+1:]
+	} else {
+		colon := strings.Index(rest, ":")
+		if colon < 0 {
+			return "", "", fmt.Errorf("invalid target '%s': expected [user@]host[:port]:path", s)
+		}
+		host, rest = rest[:colon], rest[colon:]
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return "", "", fmt.Errorf("invalid target '%s': expected [user@]host[:port]:path", s)
+	}
+	rest = rest[1:]
+
+	// A port is only taken from the path if a path follows it. Brackets are only kept around an address with a port.
+	if colon := strings.Index(rest, ":"); colon > 0 && isDigits(rest[:colon]) {
+		host, rest = host+":"+rest[:colon], rest[colon+1:]
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	if rest == "" {
+		return "", "", fmt.Errorf("invalid target '%s': blank path", s)
+	}
+
+	return user + host, rest, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// NewTailSpecData builds spec data from targets given as [user@]host[:port]:path. Targets on the same host share a
+// connection. Hosts are tagged with their hostname, unless tags are given for them in the order they first appear.
+// The spec data is validated like a loaded spec file.
+func NewTailSpecData(targets []string, identityFile string, tags []string) (*SpecData, error) {
+	var hosts []*HostSpec
+	byAddress := map[string]*HostSpec{}
+	for _, target := range targets {
+		host, err := ParseTailTarget(target)
+		if err != nil {
+			return nil, err
+		}
+
+		address := hostAddress(host)
+		if existing, ok := byAddress[address]; ok {
+			existing.Files = append(existing.Files, host.Files...)
+			continue
+		}
+
+		host.IdentityFile = identityFile
+		byAddress[address] = host
+		hosts = append(hosts, host)
+	}
+
+	if len(tags) > len(hosts) {
+		return nil, fmt.Errorf("got %d tags for %d hosts", len(tags), len(hosts))
+	}
+
+	specData := &SpecData{Hosts: map[string]*HostSpec{}}
+	for i, host := range hosts {
+		tag := host.Hostname
+		if i < len(tags) {
+			tag = tags[i]
+		} else if _, ok := specData.Hosts[tag]; ok {
+			// The same host with another user or port.
+			tag = hostAddress(host)
+		}
+
+		if _, ok := specData.Hosts[tag]; ok {
+			return nil, fmt.Errorf("tag '%s' is used for more than one host", tag)
+		}
+		specData.Hosts[tag] = host
+	}
+
+	if err := specData.Validate(); err != nil {
+		return nil, err
+	}
+
+	return specData, nil
+}
+
+// hostAddress returns the [user@]host[:port] address of a host.
+func hostAddress(host *HostSpec) string {
+	return (&JumpSpec{Hostname: host.Hostname, Port: host.Port, Username: host.Username}).String()
+}
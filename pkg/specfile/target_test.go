@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseTailTarget(t *testing.T) {
+	tests := map[string]HostSpec{
+		"web-1:/var/log/syslog":             {Hostname: "web-1"},
+		"me@web-1:/var/log/syslog":          {Hostname: "web-1", Username: "me"},
+		"me@web-1:2222:/var/log/syslog":     {Hostname: "web-1", Username: "me", Port: 2222},
+		"me@[::1]:2222:/var/log/syslog":     {Hostname: "::1", Username: "me", Port: 2222},
+		"[fe80::1]:/var/log/syslog":         {Hostname: "fe80::1"},
+		"web-1:/var/log/user@host:1:syslog": {Hostname: "web-1"},
+	}
+	for target, expected := range tests {
+		/// When
+		host, err := ParseTailTarget(target)
+
+		/// Then
+		require.NoError(t, err, target)
+		assert.Equal(t, expected.Hostname, host.Hostname, target)
+		assert.Equal(t, expected.Username, host.Username, target)
+		assert.Equal(t, expected.Port, host.Port, target)
+		require.Len(t, host.Files, 1, target)
+	}
+
+	host, err := ParseTailTarget("web-1:/var/log/user@host:1:syslog")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/log/user@host:1:syslog", host.Files[0].Path)
+
+	host, err = ParseTailTarget("web-1:22:app.log")
+	require.NoError(t, err)
+	assert.Equal(t, "app.log", host.Files[0].Path)
+
+	for _, invalid := range []string{"web-1", "web-1:", "web-1:22:", "[::1/var/log", ":/var/log", "web-1:99999:/var/log"} {
+		_, err := ParseTailTarget(invalid)
+		assert.Error(t, err, "target %q should be invalid", invalid)
+	}
+}
+
+func TestNewTailSpecData(t *testing.T) {
+	/// Given
+	// The default ssh_config is read from the home directory, which shouldn't be the one of whoever runs the tests.
+	t.Setenv("HOME", t.TempDir())
+
+	/// When
+	specData, err := NewTailSpecData([]string{
+		"web-1:/var/log/syslog",
+		"web-1:/var/log/auth.log",
+		"admin@web-1:/var/log/secure",
+		"db-1:/var/log/postgresql.log",
+	}, "/keys/id_ed25519", []string{"web"})
+
+	/// Then
+	require.NoError(t, err)
+	require.Len(t, specData.Hosts, 3)
+
+	web := specData.Hosts["web"]
+	require.NotNil(t, web)
+	assert.Len(t, web.Files, 2)
+	assert.Equal(t, "/keys/id_ed25519", web.IdentityFile)
+	assert.NotNil(t, specData.Hosts["web-1"], "the same host with another user should be tagged by its hostname")
+	assert.NotNil(t, specData.Hosts["db-1"])
+}
+
+func TestNewTailSpecData_TooManyTags(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	_, err := NewTailSpecData([]string{"web-1:/var/log/syslog"}, "", []string{"a", "b"})
+	assert.Error(t, err)
+}
+
+func TestNewTailSpecData_SshConfig(t *testing.T) {
+	/// Given
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.Mkdir(path.Join(home, ".ssh"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(home, ".ssh", "config"), []byte("Host web-1\n    HostName 10.0.0.1\n    User deploy\n"), 0600))
+
+	/// When
+	specData, err := NewTailSpecData([]string{"web-1:/var/log/syslog"}, "", nil)
+
+	/// Then
+	require.NoError(t, err)
+	web := specData.Hosts["web-1"]
+	assert.Equal(t, "10.0.0.1", web.Hostname, "targets should be resolved through the ssh_config in the home directory")
+	assert.Equal(t, "deploy", web.Username)
+	assert.Equal(t, path.Join(home, ".ssh", "id_rsa"), web.IdentityFile)
+}
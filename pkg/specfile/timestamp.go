@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// timestampPreset is a well known timestamp format with the pattern that finds it in a line.
+type timestampPreset struct {
+	pattern string
+	layout  string
+}
+
+// timestampPresets can be used as the layout of a TimestampSpec without a pattern.
+var timestampPresets = map[string]timestampPreset{
+	"rfc3339": {
+		pattern: `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`,
+		layout:  time.RFC3339Nano,
+	},
+	"syslog": {
+		pattern: `^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`,
+		layout:  time.Stamp,
+	},
+	"common-log": {
+		pattern: `\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`,
+		layout:  "02/Jan/2006:15:04:05 -0700",
+	},
+}
+
+// futureMargin is how far a timestamp without a year may be in the future and still be from the current year, to allow
+// for clocks and time zones differing between hosts. Anything later is from the year before, like a line logged on
+// December 31 and read on January 1.
+const futureMargin = 48 * time.Hour
+
+// now returns the current time, which timestamps without a year are relative to.
+var now = time.Now
+
+// TimestampSpec describes how to find and parse the timestamp of a log line.
+type TimestampSpec struct {
+	// Pattern is a regular expression finding the timestamp in a line. If it has a capture group, the first group
+	// holds the timestamp, otherwise the whole match does.
+	Pattern string `yaml:"pattern"`
+	// Layout is a Go reference time layout, or the name of a preset (rfc3339, syslog or common-log) which also
+	// provides the pattern.
+	Layout string `yaml:"layout"`
+
+	re *regexp.Regexp
+	section
+}
+
+// UnmarshalYAML marks the timestamp as given, so timestamp: {} turns off the one of the defaults.
+func (t *TimestampSpec) UnmarshalYAML(value *yaml.Node) error {
+	type plain TimestampSpec
+	return t.decode(value, (*plain)(t))
+}
+
+// Configured returns true if a timestamp format is configured.
+func (t *TimestampSpec) Configured() bool {
+	return t.Layout != ""
+}
+
+// Validate checks the TimestampSpec for errors and resolves presets.
+func (t *TimestampSpec) Validate() error {
+	if t.Layout == "" {
+		if t.Pattern != "" {
+			return errors.New("a pattern requires a layout")
+		}
+		return nil
+	}
+
+	if preset, ok := timestampPresets[t.Layout]; ok {
+		if t.Pattern == "" {
+			t.Pattern = preset.pattern
+		}
+		t.Layout = preset.layout
+	}
+
+	if t.Pattern == "" {
+		return fmt.Errorf("layout '%s' requires a pattern", t.Layout)
+	}
+
+	re, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	t.re = re
+
+	return nil
+}
+
+// Parse finds and parses the timestamp of a line. Timestamps without a year, like in syslog, are assumed to be from
+// the current year, unless that puts them in the future. Nothing is parsed before the TimestampSpec is validated.
+func (t *TimestampSpec) Parse(line []byte) (time.Time, bool) {
+	if t.re == nil {
+		return time.Time{}, false
+	}
+
+	match := t.re.FindSubmatch(line)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+
+	ts, err := time.ParseInLocation(t.Layout, string(value), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if ts.Year() == 0 {
+		current := now()
+		ts = withYear(ts, current.Year())
+		if ts.Sub(current) > futureMargin {
+			ts = withYear(ts, current.Year()-1)
+		}
+	}
+
+	return ts, true
+}
+
+func withYear(ts time.Time, year int) time.Time {
+	return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+}
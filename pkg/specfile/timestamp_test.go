@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package specfile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// setNow sets the current time timestamps without a year are relative to, for the rest of the test.
+func setNow(t *testing.T, current time.Time) {
+	previous := now
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestTimestampSpec_Presets(t *testing.T) {
+	setNow(t, time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local))
+	tests := map[string]struct {
+		line     string
+		expected time.Time
+	}{
+		"rfc3339": {
+			line:     "2023-04-05T06:07:08.123+02:00 INFO started",
+			expected: time.Date(2023, 4, 5, 6, 7, 8, 123000000, time.FixedZone("", 2*60*60)),
+		},
+		"syslog": {
+			line:     "Apr  5 06:07:08 web-1 sshd[123]: Accepted publickey",
+			expected: time.Date(2023, 4, 5, 6, 7, 8, 0, time.Local),
+		},
+		"common-log": {
+			line:     `127.0.0.1 - - [05/Apr/2023:06:07:08 -0700] "GET / HTTP/1.1" 200 42`,
+			expected: time.Date(2023, 4, 5, 6, 7, 8, 0, time.FixedZone("", -7*60*60)),
+		},
+	}
+
+	for preset, tc := range tests {
+		/// Given
+		ts := &TimestampSpec{Layout: preset}
+		require.NoError(t, ts.Validate(), preset)
+
+		/// When
+		parsed, ok := ts.Parse([]byte(tc.line))
+
+		/// Then
+		assert.True(t, ok, preset)
+		assert.True(t, tc.expected.Equal(parsed), "%s: expected %v, got %v", preset, tc.expected, parsed)
+	}
+}
+
+func TestTimestampSpec_SyslogYear(t *testing.T) {
+	tests := map[string]struct {
+		now      time.Time
+		line     string
+		expected int
+	}{
+		"this year": {now: time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local), line: "Apr  5 06:07:08 web-1 cron: done",
+			expected: 2023},
+		"new year": {now: time.Date(2024, 1, 1, 0, 1, 0, 0, time.Local), line: "Dec 31 23:59:59 web-1 cron: done",
+			expected: 2023},
+		"same day": {now: time.Date(2023, 12, 31, 23, 0, 0, 0, time.Local), line: "Dec 31 23:59:59 web-1 cron: done",
+			expected: 2023},
+		"slightly ahead": {now: time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local), line: "Jun  2 12:00:00 web-1 cron: done",
+			expected: 2023},
+		"days ahead": {now: time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local), line: "Jun  4 12:00:00 web-1 cron: done",
+			expected: 2022},
+	}
+
+	for name, tc := range tests {
+		/// Given
+		setNow(t, tc.now)
+		ts := &TimestampSpec{Layout: "syslog"}
+		require.NoError(t, ts.Validate())
+
+		/// When
+		parsed, ok := ts.Parse([]byte(tc.line))
+
+		/// Then
+		assert.True(t, ok, name)
+		assert.Equal(t, tc.expected, parsed.Year(), name)
+	}
+}
+
+func TestTimestampSpec_CustomPattern(t *testing.T) {
+	/// Given
+	ts := &TimestampSpec{Pattern: `time=(\S+ \S+)`, Layout: "2006-01-02 15:04:05"}
+	require.NoError(t, ts.Validate())
+
+	/// When
+	parsed, ok := ts.Parse([]byte("level=info time=2023-04-05 06:07:08 msg=started"))
+	_, noMatch := ts.Parse([]byte("level=info msg=no time"))
+
+	/// Then
+	assert.True(t, ok)
+	assert.True(t, time.Date(2023, 4, 5, 6, 7, 8, 0, time.Local).Equal(parsed), "the capture group should be parsed")
+	assert.False(t, noMatch)
+}
+
+func TestTimestampSpec_Validate(t *testing.T) {
+	assert.NoError(t, (&TimestampSpec{}).Validate(), "no timestamp should be fine")
+	assert.Error(t, (&TimestampSpec{Pattern: `\d+`}).Validate(), "a pattern requires a layout")
+	assert.Error(t, (&TimestampSpec{Layout: "2006-01-02"}).Validate(), "a custom layout requires a pattern")
+	assert.Error(t, (&TimestampSpec{Pattern: `(`, Layout: "2006-01-02"}).Validate(), "the pattern should compile")
+
+	_, ok := (&TimestampSpec{Layout: "rfc3339"}).Parse([]byte("2023-04-05T06:07:08Z"))
+	assert.False(t, ok, "nothing should be parsed before validating")
+}
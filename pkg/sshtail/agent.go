@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"net"
+	"os"
+)
+
+// AgentSocketEnv is the environment variable pointing at the ssh-agent socket.
+const AgentSocketEnv = "SSH_AUTH_SOCK"
+
+// DialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK. A nil agent is returned if the variable isn't set.
+// The returned closer must be closed once the agent isn't needed anymore.
+func DialAgent() (agent.ExtendedAgent, io.Closer, error) {
+	socket := os.Getenv(AgentSocketEnv)
+	if socket == "" {
+		return nil, nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", socket, err)
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// dialAgentFor connects to the ssh-agent if the host's auth preference uses it. Failing to reach the agent is only an
+// error if the host relies on the agent exclusively.
+func dialAgentFor(e endpoint) (agent.Agent, io.Closer, error) {
+	if e.auth == specfile.AuthFile {
+		return nil, nil, nil
+	}
+
+	agentClient, conn, err := DialAgent()
+	if err != nil {
+		if e.auth == specfile.AuthAgent {
+			return nil, nil, err
+		}
+
+		_, _ = fmt.Fprintf(e.status, "Warning: %v, falling back to the identity file for %s\n", err, e.tag)
+		return nil, nil, nil
+	}
+
+	return agentClient, conn, nil
+}
+
+// authMethods builds the auth methods for an endpoint according to its auth preference. The agent may be nil if no
+// ssh-agent is available.
+//
+// The agent and file keys are offered through a single public key method, because the SSH client won't try a second
+// method of the same kind after the first one fails.
+func authMethods(e endpoint, agentClient agent.Agent) ([]ssh.AuthMethod, error) {
+	switch e.auth {
+	case specfile.AuthFile:
+		return fileAuthMethods(e.identityFiles(), e.signers)
+	case specfile.AuthAgent:
+		if agentClient == nil {
+			return nil, fmt.Errorf("agent auth requires a running ssh-agent, but %s is not set", AgentSocketEnv)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeysCallback(agentClient.Signers)}, nil
+	case "", specfile.AuthBoth:
+		if agentClient == nil {
+			return fileAuthMethods(e.identityFiles(), e.signers)
+		}
+		signers, err := agentThenFileSigners(agentClient, e.identityFiles(), e.signers)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth '%s'", e.auth)
+	}
+}
+
+func fileAuthMethods(identityFiles []string, cache *signerCache) ([]ssh.AuthMethod, error) {
+	signers, err := fileSigners(identityFiles, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
+
+// fileSigners loads the keys of the identity files. Like ssh, identity files that don't exist are skipped, as long as
+// the key of at least one of them can be loaded.
+func fileSigners(identityFiles []string, cache *signerCache) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	var missing error
+	for _, identityFile := range identityFiles {
+		signer, err := cache.load(identityFile)
+		if errors.Is(err, os.ErrNotExist) {
+			if missing == nil {
+				missing = fmt.Errorf("failed to load key from %s: %w", identityFile, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key from %s: %w", identityFile, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, missing
+	}
+
+	return signers, nil
+}
+
+// agentThenFileSigners returns the agent keys followed by the keys in the identity files that exist. An encrypted
+// identity file is skipped if the agent holds its key, so there's no need to ask for its passphrase. The keys are
+// loaded before connecting, so asking for a passphrase doesn't count towards the handshake timeout.
+func agentThenFileSigners(agentClient agent.Agent, identityFiles []string, cache *signerCache) ([]ssh.Signer, error) {
+	signers, err := agentClient.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+
+	var files []string
+	for _, identityFile := range identityFiles {
+		if len(signers) == 0 || !agentHoldsIdentity(signers, identityFile) {
+			files = append(files, identityFile)
+		}
+	}
+	if len(files) == 0 {
+		return signers, nil
+	}
+
+	keys, err := fileSigners(files, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signers, keys...), nil
+}
+
+// agentHoldsIdentity returns true if the identity file doesn't have to be loaded next to the agent signers, because it
+// doesn't exist, or it's encrypted and the agent holds its key. The public half of an encrypted key is taken from the
+// key file if it has one, or from the .pub file next to it. If neither has it, the file is loaded like any other, so its
+// passphrase is asked for.
+func agentHoldsIdentity(signers []ssh.Signer, identityFile string) bool {
+	key, err := os.ReadFile(identityFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if _, err = ssh.ParsePrivateKey(key); !errors.As(err, &missing) {
+		return false
+	}
+
+	public := missing.PublicKey
+	if public == nil {
+		if public, err = loadPublicKey(identityFile + ".pub"); err != nil {
+			return false
+		}
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), public.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func generateKey(t *testing.T) ed25519.PrivateKey {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err, "failed to generate ed25519 key")
+	return privateKey
+}
+
+func writeKeyFile(t *testing.T, privateKey ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err, "failed to marshal private key")
+
+	identityFile := path.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(identityFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err, "failed to write private key")
+	return identityFile
+}
+
+// writeEncryptedKeyFile writes the key encrypted with a passphrase, along with its public half in a .pub file if asked
+// to.
+func writeEncryptedKeyFile(t *testing.T, privateKey ed25519.PrivateKey, withPublicKey bool) string {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err, "failed to marshal private key")
+	block, err := x509.EncryptPEMBlock(rand.Reader, "PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err, "failed to encrypt private key")
+
+	identityFile := path.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(identityFile, pem.EncodeToMemory(block), 0600), "failed to write private key")
+
+	if withPublicKey {
+		publicKey, err := ssh.NewPublicKey(privateKey.Public())
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(identityFile+".pub", ssh.MarshalAuthorizedKey(publicKey), 0644), "failed to write public key")
+	}
+	return identityFile
+}
+
+func newKeyring(t *testing.T, keys ...ed25519.PrivateKey) agent.Agent {
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}), "failed to add key to agent")
+	}
+	return keyring
+}
+
+// handshake authenticates against an in-process SSH server that only accepts the authorized key.
+func handshake(t *testing.T, authorized ed25519.PrivateKey, auth []ssh.AuthMethod) error {
+	authorizedKey, err := ssh.NewPublicKey(authorized.Public())
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(generateKey(t))
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serverConn, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				_ = ch.Reject(ssh.Prohibited, "no channels")
+			}
+		}()
+		_ = serverConn.Wait()
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+func TestAuthMethods_Agent(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	e := endpoint{auth: specfile.AuthAgent}
+
+	/// When
+	auth, err := authMethods(e, newKeyring(t, key))
+	require.NoError(t, err)
+
+	/// Then
+	assert.NoError(t, handshake(t, key, auth), "agent key should be accepted")
+	assert.Error(t, handshake(t, generateKey(t), auth), "server should reject a key it doesn't know")
+}
+
+func TestAuthMethods_AgentRequired(t *testing.T) {
+	/// Given
+	e := endpoint{auth: specfile.AuthAgent}
+
+	/// When
+	_, err := authMethods(e, nil)
+
+	/// Then
+	assert.Error(t, err, "agent auth without an agent should fail")
+}
+
+func TestAuthMethods_File(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	e := endpoint{auth: specfile.AuthFile, identityFile: writeKeyFile(t, key)}
+
+	/// When
+	auth, err := authMethods(e, newKeyring(t, generateKey(t)))
+	require.NoError(t, err)
+
+	/// Then
+	assert.NoError(t, handshake(t, key, auth), "file key should be accepted")
+}
+
+func TestAuthMethods_FileTriesEveryIdentityFile(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	e := endpoint{auth: specfile.AuthFile, identityFile: path.Join(t.TempDir(), "missing"),
+		extraIdentityFiles: []string{writeKeyFile(t, generateKey(t)), writeKeyFile(t, key)}}
+
+	/// When
+	auth, err := authMethods(e, nil)
+	require.NoError(t, err, "a missing identity file should be skipped if there are others")
+
+	/// Then
+	assert.NoError(t, handshake(t, key, auth), "the key of the last identity file should be tried as well")
+
+	/// Given
+	e = endpoint{auth: specfile.AuthFile, identityFile: path.Join(t.TempDir(), "missing"),
+		extraIdentityFiles: []string{path.Join(t.TempDir(), "also-missing")}}
+
+	/// When
+	_, err = authMethods(e, nil)
+
+	/// Then
+	assert.Error(t, err, "there should be at least one identity file")
+}
+
+func TestAuthMethods_BothFallsBackToFile(t *testing.T) {
+	/// Given
+	fileKey := generateKey(t)
+	e := endpoint{auth: specfile.AuthBoth, identityFile: writeKeyFile(t, fileKey)}
+
+	/// When
+	auth, err := authMethods(e, newKeyring(t, generateKey(t)))
+	require.NoError(t, err)
+
+	/// Then
+	assert.NoError(t, handshake(t, fileKey, auth), "file key should be tried after the agent keys")
+}
+
+func TestAuthMethods_BothWithoutIdentityFile(t *testing.T) {
+	/// Given
+	agentKey := generateKey(t)
+	e := endpoint{auth: specfile.AuthBoth, identityFile: path.Join(t.TempDir(), "missing")}
+
+	/// When
+	auth, err := authMethods(e, newKeyring(t, agentKey))
+	require.NoError(t, err)
+
+	/// Then
+	assert.NoError(t, handshake(t, agentKey, auth), "a missing identity file should not matter if the agent has the key")
+}
+
+func TestAuthMethods_BothWithEncryptedIdentityFile(t *testing.T) {
+	/// Given
+	agentKey := generateKey(t)
+	e := endpoint{auth: specfile.AuthBoth, identityFile: writeEncryptedKeyFile(t, agentKey, true)}
+
+	/// When
+	auth, err := authMethods(e, newKeyring(t, agentKey))
+	require.NoError(t, err)
+
+	/// Then
+	// Asking for the passphrase fails without a terminal, so the handshake only succeeds if the file is skipped.
+	assert.NoError(t, handshake(t, agentKey, auth), "an encrypted identity file should be skipped if the agent has the key")
+}
+
+func TestAgentHoldsIdentity(t *testing.T) {
+	/// Given
+	agentKey := generateKey(t)
+	signers, err := newKeyring(t, agentKey).Signers()
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		identityFile string
+		expected     bool
+	}{
+		"missing":                     {identityFile: path.Join(t.TempDir(), "missing"), expected: true},
+		"unencrypted":                 {identityFile: writeKeyFile(t, agentKey), expected: false},
+		"encrypted agent key":         {identityFile: writeEncryptedKeyFile(t, agentKey, true), expected: true},
+		"encrypted other key":         {identityFile: writeEncryptedKeyFile(t, generateKey(t), true), expected: false},
+		"encrypted without .pub file": {identityFile: writeEncryptedKeyFile(t, agentKey, false), expected: false},
+	}
+	for name, tc := range tests {
+		/// When
+		holds := agentHoldsIdentity(signers, tc.identityFile)
+
+		/// Then
+		assert.Equal(t, tc.expected, holds, name)
+	}
+}
+
+func TestDialAgent(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	keyring := newKeyring(t, key)
+
+	socket := path.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv(AgentSocketEnv, socket)
+
+	/// When
+	agentClient, conn, err := DialAgent()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	auth, err := authMethods(endpoint{auth: specfile.AuthAgent}, agentClient)
+	require.NoError(t, err)
+
+	/// Then
+	assert.NoError(t, handshake(t, key, auth), "key served by the agent socket should be accepted")
+}
+
+func TestDialAgentFor_FallsBackToIdentityFile(t *testing.T) {
+	/// Given
+	t.Setenv(AgentSocketEnv, path.Join(t.TempDir(), "missing.sock"))
+	status := &statusRecorder{}
+	e := endpoint{tag: "web", auth: specfile.AuthBoth, status: status}
+
+	/// When
+	agentClient, conn, err := dialAgentFor(e)
+
+	/// Then
+	require.NoError(t, err, "an unreachable agent should not matter if the identity file can be used")
+	assert.Nil(t, agentClient)
+	assert.Nil(t, conn)
+	assert.Contains(t, status.String(), "falling back to the identity file for web")
+}
+
+func TestDialAgent_NotConfigured(t *testing.T) {
+	/// Given
+	t.Setenv(AgentSocketEnv, "")
+
+	/// When
+	agentClient, conn, err := DialAgent()
+
+	/// Then
+	assert.NoError(t, err)
+	assert.Nil(t, agentClient)
+	assert.Nil(t, conn)
+}
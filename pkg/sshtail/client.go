@@ -18,99 +18,296 @@
 package sshtail
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/drognisep/sshtail/pkg/specfile"
 	"golang.org/x/crypto/ssh"
-	"net"
-	"strconv"
+	"io"
+	"os"
+	"sync"
+	"time"
 )
 
-// TailChannelWriter is a wrapper around a channel that implements the io.Writer interface.
+// TailChannelWriter is a wrapper around a channel that implements the io.Writer interface. Every write is expected to
+// be a single line, or a record of lines, which is sent as an event based on the given one.
 type TailChannelWriter struct {
-	prefix string
-	ch     chan<- string
+	event LogEvent
+	ch    chan<- LogEvent
+	done  <-chan struct{}
 }
 
 func (t TailChannelWriter) Write(b []byte) (int, error) {
-	t.ch <- fmt.Sprintf("%s | %s", t.prefix, string(b))
+	e := t.event
+	e.Line = bytes.TrimSuffix(b, []byte{'\n'})
+	e.Received = time.Now()
+
+	select {
+	case t.ch <- e:
+	case <-t.done:
+		// Nobody is reading anymore, so drop the output instead of blocking the session.
+	}
 	return len(b), nil
 }
 
-// TailSshClient associates a client connection with a host tag and spec data.
+// TailSshClient associates a client connection with a host tag and spec data. Every file tailed on the host gets its
+// own session over the shared connection.
 type TailSshClient struct {
-	tag     string
-	host    *specfile.HostSpec
-	client  *ssh.Client
+	tag    string
+	host   *specfile.HostSpec
+	status io.Writer
+
+	mu       sync.Mutex
+	client   *ssh.Client
+	sessions []*tailSession
+	done     chan struct{}
+	closed   bool
+	// positions holds where each file, by tag, left off when the connection was lost.
+	positions map[string]filePosition
+	// fileRetries holds the backoff of restarting the tail of each file, by tag, after it exited on its own.
+	fileRetries map[string]*retryState
+	// stopped holds the tags of files that ran out of restarts, and aren't tailed anymore.
+	stopped map[string]bool
+
+	// lines overrides the backlog lines of the host if it's not negative.
+	lines int
+	// since drops lines of the backlog older than this time, if set.
+	since time.Time
+
+	// grep is the command filtering the output on the host, if it has a remote filter that grep can narrow down.
+	// It's unset if the remote grep turns out not to support it.
+	grep        string
+	grepChecked bool
+
+	jumps     *jumpPool
+	ownsJumps bool
+}
+
+// tailSession is a single file being tailed on a host.
+type tailSession struct {
+	file    *specfile.FileSpec
+	prefix  string
 	session *ssh.Session
+	pos     *sessionPosition
+	lines   *lineWriter
+	records *recordWriter
+	started time.Time
 }
 
-func noOpBanner(_ string) error { return nil }
+// flush passes on what's left of a partial line and the last record, once the session ended.
+func (ts *tailSession) flush() {
+	_ = ts.lines.Close()
+	if ts.records != nil {
+		_ = ts.records.Close()
+	}
+}
 
+// NewTailSshClient connects to the host, tunneling through its jump hosts if it has any.
 func NewTailSshClient(hostTag string, host *specfile.HostSpec) (*TailSshClient, error) {
-	authMethod, err := LoadKey(host.IdentityFile)
+	jumps := newJumpPool(os.Stderr)
+	client, err := newTailSshClient(hostTag, host, jumps)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load key from %s: %w", host.IdentityFile, err)
+		_ = jumps.Close()
+		return nil, err
 	}
 
-	addr := net.JoinHostPort(host.Hostname, strconv.Itoa(host.Port))
-	config := &ssh.ClientConfig{
-		User:            host.Username,
-		Auth:            []ssh.AuthMethod{authMethod},
-		BannerCallback:  noOpBanner,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := ssh.Dial("tcp", addr, config)
+	client.ownsJumps = true
+	return client, nil
+}
+
+// newTailSshClient connects to the host, sharing jump host connections through the given pool.
+func newTailSshClient(hostTag string, host *specfile.HostSpec, jumps *jumpPool) (*TailSshClient, error) {
+	client, err := jumps.dial(hostTag, host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+		return nil, err
 	}
 
 	clientPair := &TailSshClient{
-		client: client,
-		tag:    hostTag,
-		host:   host,
+		client:    client,
+		done:      make(chan struct{}),
+		positions: map[string]filePosition{},
+		lines:     -1,
+		jumps:     jumps,
+		tag:       hostTag,
+		host:      host,
+		status:    os.Stderr,
 	}
+	clientPair.grep, _ = grepCommand(&host.RemoteFilter)
 	return clientPair, nil
 }
 
-// Started returns true if the client has an active session.
+// Started returns true if the client has active sessions.
 func (c *TailSshClient) Started() bool {
-	return c.session != nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.sessions) > 0
 }
 
-// StartSession starts a new tail session on the client.
-func (c *TailSshClient) StartSession(ch chan<- string) error {
-	if c.session != nil {
+// StartSession starts a tail session for every file of the host. If one of them fails, the already started sessions
+// are closed.
+func (c *TailSshClient) StartSession(ch chan<- LogEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("client closed")
+	}
+
+	if len(c.sessions) > 0 {
 		return errors.New("session already started")
 	}
 
+	return c.startSessions(ch)
+}
+
+// startSessions starts the tail sessions on the current connection, the caller must hold the lock.
+func (c *TailSshClient) startSessions(ch chan<- LogEvent) error {
+	if !c.grepChecked {
+		c.grepChecked = true
+		filter := &c.host.RemoteFilter
+		if p := unportablePattern(append(append([]string{}, filter.Include...), filter.Exclude...)); p != "" {
+			_, _ = fmt.Fprintf(c.status, "%s: remote filter pattern %q doesn't mean the same to grep on the host, filtering locally instead\n", c.tag, p)
+		} else if c.grep != "" && !c.probeGrep() {
+			_, _ = fmt.Fprintf(c.status, "%s: grep on the host doesn't support remote filtering, filtering locally instead\n", c.tag)
+			c.grep = ""
+		}
+	}
+
+	for _, file := range c.host.TailFiles() {
+		if c.stopped[file.Tag] {
+			continue
+		}
+
+		ts, err := c.startFile(file, ch)
+		if err != nil {
+			c.closeSessions()
+			return fmt.Errorf("file %s: %w", file.Path, err)
+		}
+
+		c.sessions = append(c.sessions, ts)
+	}
+
+	return nil
+}
+
+// startFile starts the tail session of a single file, the caller must hold the lock.
+func (c *TailSshClient) startFile(file *specfile.FileSpec, ch chan<- LogEvent) (*tailSession, error) {
+	event := LogEvent{Tag: c.tag, Hostname: c.host.Hostname, File: file.Path}
+	if len(c.host.TailFiles()) > 1 || file.TagSet() {
+		event.FileTag = file.Tag
+	}
+
+	return c.startTailSession(file, event, ch)
+}
+
+func (c *TailSshClient) startTailSession(file *specfile.FileSpec, event LogEvent, ch chan<- LogEvent) (*tailSession, error) {
 	session, err := c.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	prefix := event.Source()
+	var out io.Writer = TailChannelWriter{event, ch, c.done}
+	cmd := tailCommand{path: file.Path, follow: c.host.Follow, lines: c.backlogLines(), grep: c.grep}
+	if p, ok := c.positions[file.Tag]; ok {
+		cmd.resume = &p
+	} else if !c.since.IsZero() {
+		// Only the backlog needs to be searched for lines since the given time, everything after it is newer anyway.
+		out = &sinceWriter{since: c.since, timestamp: &c.host.Timestamp, next: out, prefix: prefix, lines: cmd.lines, status: c.status}
+	}
+
+	var records *recordWriter
+	if c.host.Multiline.Configured() {
+		records = newRecordWriter(&c.host.Multiline, out)
+		out = records
 	}
 
-	session.Stdout = TailChannelWriter{c.tag, ch}
+	pos := &sessionPosition{}
+	lines := newLineWriter(out)
+	if c.grep == "" {
+		session.Stdout = positionWriter{pos, lines}
+	} else {
+		pos.filtered = true
+		session.Stdout = &offsetWriter{pos: pos, next: lines}
+	}
+	session.Stderr = &stderrWriter{prefix: prefix, path: file.Path, pos: pos, status: c.status}
 
-	cmd := fmt.Sprintf("tail -f %s", c.host.File)
-	err = session.Start(cmd)
+	err = session.Start(cmd.String())
 	if err != nil {
-		return fmt.Errorf("failed to execute tail session command: %w", err)
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to execute tail session command: %w", err)
 	}
 
-	c.session = session
+	return &tailSession{file: file, prefix: prefix, session: session, pos: pos, lines: lines, records: records, started: time.Now()}, nil
+}
 
-	return nil
+// probeGrep returns true if grep on the host supports the options used for remote filtering. The caller must hold the
+// lock.
+func (c *TailSshClient) probeGrep() bool {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return false
+	}
+	defer session.Close()
+
+	out, err := session.Output(grepProbe)
+	return err == nil && string(out) == grepProbeOutput
 }
 
-// Close closes the client connection and the session.
+// backlogLines returns how many lines are shown from the end of a file when tailing starts.
+func (c *TailSshClient) backlogLines() int {
+	switch {
+	case c.lines >= 0:
+		return c.lines
+	case !c.since.IsZero():
+		return sinceBacklogLines
+	case c.host.Lines != nil:
+		return *c.host.Lines
+	default:
+		return defaultBacklogLines
+	}
+}
+
+// savePositions remembers where the sessions left off, so they can be resumed on the next connection. The caller must
+// hold the lock, and the sessions must have ended so no more output is counted.
+func (c *TailSshClient) savePositions() {
+	for _, ts := range c.sessions {
+		if p, ok := ts.pos.position(); ok {
+			c.positions[ts.file.Tag] = p
+		}
+	}
+}
+
+// closeSessions closes all sessions, the caller must hold the lock.
+func (c *TailSshClient) closeSessions() {
+	for _, ts := range c.sessions {
+		_ = ts.session.Signal(ssh.SIGINT)
+		_ = ts.session.Close()
+		ts.flush()
+	}
+	c.sessions = nil
+}
+
+// Close closes the client connection and its sessions. A closed client is not reconnected anymore.
 func (c *TailSshClient) Close() error {
-	if c.session != nil {
-		_ = c.session.Signal(ssh.SIGINT)
-		_ = c.session.Close()
-		c.session = nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
 	}
+	c.closed = true
+	close(c.done)
+
+	c.closeSessions()
+	err := c.client.Close()
 
-	if err := c.client.Close(); err != nil {
+	if c.ownsJumps {
+		_ = c.jumps.Close()
+	}
+
+	if err != nil {
 		return fmt.Errorf("failed to close client: %v", err)
 	}
 
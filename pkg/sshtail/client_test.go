@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTailSshClient_BacklogLines(t *testing.T) {
+	hostLines := 50
+	since := time.Now().Add(-time.Hour)
+
+	tests := map[string]struct {
+		lines     int
+		since     time.Time
+		hostLines *int
+		expected  int
+	}{
+		"default":          {lines: -1, expected: defaultBacklogLines},
+		"host":             {lines: -1, hostLines: &hostLines, expected: 50},
+		"since":            {lines: -1, since: since, hostLines: &hostLines, expected: sinceBacklogLines},
+		"lines":            {lines: 5, hostLines: &hostLines, expected: 5},
+		"lines with since": {lines: 20000, since: since, expected: 20000},
+		"no backlog":       {lines: 0, hostLines: &hostLines, expected: 0},
+	}
+
+	for name, tc := range tests {
+		/// Given
+		c := &TailSshClient{lines: tc.lines, since: tc.since, host: &specfile.HostSpec{Lines: tc.hostLines}}
+
+		/// When
+		lines := c.backlogLines()
+
+		/// Then
+		assert.Equal(t, tc.expected, lines, name)
+	}
+}
+
+func TestTailSshClient_RemoteFilterFallsBackToLocal(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	file := path.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("took 250 ms\ntook 25 ms\n"), 0644))
+
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: file,
+		RemoteFilter: specfile.FilterSpec{Include: []string{`\d{3} ms`}}}
+	require.NoError(t, host.Validate())
+
+	client, err := NewTailSshClient("web", host)
+	require.NoError(t, err)
+	defer client.Close()
+	status := &statusRecorder{}
+	client.status = status
+
+	/// When
+	ch := make(chan LogEvent, 16)
+	require.NoError(t, client.StartSession(ch))
+
+	/// Then
+	assert.Equal(t, "took 250 ms", nextLine(t, ch), "grep should not drop lines the pattern matches")
+	assert.Equal(t, "took 25 ms", nextLine(t, ch), "the lines should be left for the local filter")
+	assert.Equal(t, "web: remote filter pattern \"\\\\d{3} ms\" doesn't mean the same to grep on the host, filtering locally instead\n", status.String())
+}
+
+func TestTailSshClient_FileTags(t *testing.T) {
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	file := path.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("started\n"), 0644))
+
+	tests := map[string]struct {
+		file     string
+		files    []*specfile.FileSpec
+		expected string
+	}{
+		"file":            {file: file, expected: "web"},
+		"file in files":   {files: []*specfile.FileSpec{{Path: file}}, expected: "web"},
+		"tagged in files": {files: []*specfile.FileSpec{{Path: file, Tag: "app"}}, expected: "web/app"},
+	}
+
+	for name, tc := range tests {
+		/// Given
+		host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+			IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+			HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: tc.file, Files: tc.files}
+		require.NoError(t, host.Validate(), name)
+
+		client, err := NewTailSshClient("web", host)
+		require.NoError(t, err, name)
+
+		/// When
+		ch := make(chan LogEvent, 16)
+		require.NoError(t, client.StartSession(ch), name)
+
+		/// Then
+		select {
+		case e := <-ch:
+			assert.Equal(t, tc.expected, e.Source(), "%s: a configured file tag should be shown even for a single file", name)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "timed out waiting for a line", name)
+		}
+		_ = client.Close()
+	}
+}
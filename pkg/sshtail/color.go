@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"hash/fnv"
+)
+
+// colorCodes are the SGR parameters of the color names in specfile.Colors.
+var colorCodes = map[string]string{
+	"black":          "30",
+	"red":            "31",
+	"green":          "32",
+	"yellow":         "33",
+	"blue":           "34",
+	"magenta":        "35",
+	"cyan":           "36",
+	"white":          "37",
+	"bright-black":   "90",
+	"bright-red":     "91",
+	"bright-green":   "92",
+	"bright-yellow":  "93",
+	"bright-blue":    "94",
+	"bright-magenta": "95",
+	"bright-cyan":    "96",
+	"bright-white":   "97",
+}
+
+// autoColors are picked from for hosts without a color of their own. Black and white are left out, since one of them
+// is usually the background.
+var autoColors = []string{
+	"cyan", "green", "yellow", "blue", "magenta", "red",
+	"bright-cyan", "bright-green", "bright-yellow", "bright-blue", "bright-magenta", "bright-red",
+}
+
+// HostColors assigns a color to every host tag. Hosts keep the color set in their spec, and the others get one picked
+// by a hash of their tag, so a host has the same color every time.
+type HostColors struct {
+	colors map[string]string
+}
+
+// NewHostColors assigns colors to the hosts of the spec.
+func NewHostColors(specData *specfile.SpecData) *HostColors {
+	colors := map[string]string{}
+	for tag, host := range specData.Hosts {
+		if host.Color != "" {
+			colors[tag] = host.Color
+		}
+	}
+	return &HostColors{colors: colors}
+}
+
+// Color returns the name of the color of a host tag.
+func (h *HostColors) Color(tag string) string {
+	if c, ok := h.colors[tag]; ok {
+		return c
+	}
+
+	sum := fnv.New32a()
+	_, _ = sum.Write([]byte(tag))
+	return autoColors[sum.Sum32()%uint32(len(autoColors))]
+}
+
+// Paint wraps the text in the escape codes for the color of the host tag. A nil HostColors leaves the text as it is,
+// for output without colors.
+func (h *HostColors) Paint(tag string, text string) string {
+	if h == nil {
+		return text
+	}
+	return "\x1b[" + colorCodes[h.Color(tag)] + "m" + text + "\x1b[0m"
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHostColors(t *testing.T) {
+	/// Given
+	specData := &specfile.SpecData{Hosts: map[string]*specfile.HostSpec{
+		"web": {},
+		"db":  {Color: "bright-white"},
+	}}
+
+	/// When
+	colors := NewHostColors(specData)
+
+	/// Then
+	assert.Equal(t, "bright-white", colors.Color("db"))
+	assert.Equal(t, colors.Color("web"), NewHostColors(specData).Color("web"), "colors should be stable")
+	assert.Contains(t, autoColors, colors.Color("web"))
+	assert.Equal(t, "\x1b[97mdb\x1b[0m", colors.Paint("db", "db"))
+}
+
+func TestHostColors_Formatters(t *testing.T) {
+	/// Given
+	colors := &HostColors{colors: map[string]string{"web": "red"}}
+	e := LogEvent{Tag: "web", FileTag: "app", Line: []byte("started")}
+
+	/// When
+	text, err := TextFormatter{Colors: colors}.Format(e)
+	require.NoError(t, err)
+	plain, err := TextFormatter{}.Format(e)
+	require.NoError(t, err)
+	tmpl, err := NewTemplateFormatter(`{{.Color .Tag}} {{.Line | .Color}}`, colors, nil)
+	require.NoError(t, err)
+	templated, err := tmpl.Format(e)
+	require.NoError(t, err)
+
+	/// Then
+	assert.Equal(t, "\x1b[31mweb/app\x1b[0m | started\n", string(text))
+	assert.Equal(t, "web/app | started\n", string(plain))
+	assert.Equal(t, "\x1b[31mweb\x1b[0m \x1b[31mstarted\x1b[0m\n", string(templated))
+}
+
+func TestHighlighter(t *testing.T) {
+	/// Given
+	h, err := NewHighlighter([]string{`ERROR`, `req-\d+`, `OR r`})
+	require.NoError(t, err)
+
+	/// When
+	highlighted := h.Highlight([]byte("ERROR req-42 failed"))
+	unmatched := h.Highlight([]byte("INFO ok"))
+
+	/// Then
+	assert.Equal(t, "\x1b[1;31mERROR\x1b[0m\x1b[1;33m \x1b[0m\x1b[1;32mreq-42\x1b[0m failed", string(highlighted))
+	assert.Equal(t, "INFO ok", string(unmatched))
+	assert.Equal(t, "ok", string((*Highlighter)(nil).Highlight([]byte("ok"))))
+
+	_, err = NewHighlighter([]string{`(`})
+	assert.Error(t, err)
+}
+
+func TestHighlighter_TextFormatter(t *testing.T) {
+	/// Given
+	h, err := NewHighlighter([]string{`failed`})
+	require.NoError(t, err)
+	colors := &HostColors{colors: map[string]string{"web": "cyan"}}
+
+	/// When
+	b, err := TextFormatter{Colors: colors, Highlight: h}.Format(LogEvent{Tag: "web", Line: []byte("login failed")})
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, "\x1b[36mweb\x1b[0m | login \x1b[1;31mfailed\x1b[0m\n", string(b))
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"strings"
+)
+
+// defaultBacklogLines is the number of lines shown from the end of a file when tailing starts.
+const defaultBacklogLines = 10
+
+// sinceBacklogLines is how far back a file is read by default to find the lines newer than a point in time.
+const sinceBacklogLines = 10000
+
+// positionMarker starts the line the remote command writes to stderr to report the inode and byte offset it starts
+// tailing from.
+const positionMarker = "sshtail-position"
+
+// unknownInode is reported in place of the inode of a file that doesn't exist when the remote command starts.
+const unknownInode = "-"
+
+// tailCommand builds the remote command tailing a file.
+type tailCommand struct {
+	path   string
+	follow string
+	// lines is the number of lines to show from the end of the file, if there is no position to resume from.
+	lines int
+	// resume is the position to resume from. The file is read from its start instead if it was rotated (its inode
+	// changed) or truncated in the meantime. A position without an inode matches whatever file currently has the
+	// name, which is the case after following a rotation by name.
+	resume *filePosition
+	// grep filters the output on the remote host, if set.
+	grep string
+}
+
+// String returns the command for the remote shell. Since the login shell of the remote user isn't necessarily a POSIX
+// shell, the script is run by sh, and every value interpolated into it is quoted.
+func (t tailCommand) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "f=%s; ", shellQuotePath(t.path))
+	// A file that doesn't exist (yet) is still handed to tail, which waits for it to appear when following by name. Its
+	// inode is reported as "-" then, and it's read from the start once it appears.
+	b.WriteString(`set -- $(ls -di -- "$f" 2>/dev/null); `)
+	b.WriteString(`if [ $# -ge 1 ] && size=$(wc -c 2>/dev/null < "$f") && [ -n "$size" ]; then ino=$1; size=$((size)); `)
+	if t.resume == nil {
+		fmt.Fprintf(&b, `start=$((size - $(tail -n %d -- "$f" | wc -c))); `, t.lines)
+	} else {
+		inode := shellQuote(t.resume.inode)
+		fmt.Fprintf(&b, `if { [ -z %s ] || [ "$ino" = %s ]; } && [ "$size" -ge %d ]; then start=%d; else start=0; fi; `,
+			inode, inode, t.resume.offset, t.resume.offset)
+	}
+	fmt.Fprintf(&b, `else ino=%s; start=0; fi; `, unknownInode)
+	fmt.Fprintf(&b, `echo "%s $ino $start" >&2; `, positionMarker)
+	// Rotations and truncations are told apart by the messages of tail, so they have to be in English whatever the
+	// locale of the remote user is. Only tail runs in the C locale, grep keeps matching characters of the user's locale.
+	if t.grep == "" {
+		fmt.Fprintf(&b, `LC_ALL=C exec tail -c +$((start + 1)) %s -- "$f"`, followFlag(t.follow))
+	} else {
+		fmt.Fprintf(&b, `LC_ALL=C tail -c +$((start + 1)) %s -- "$f" | %s`, followFlag(t.follow), t.grep)
+	}
+
+	return "sh -c " + shellQuote(b.String())
+}
+
+func followFlag(follow string) string {
+	if follow == specfile.FollowDescriptor {
+		return "-f"
+	}
+
+	return "-F"
+}
@@ -0,0 +1,350 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// hostileValues try to break out of the quoting, start options or expand in some way.
+var hostileValues = []string{
+	"",
+	"plain",
+	"with spaces",
+	"it's",
+	"'",
+	"''",
+	`"double"`,
+	`back\slash\`,
+	"$(touch pwned)",
+	"`touch pwned`",
+	"${HOME}",
+	"; touch pwned",
+	"x'; touch pwned; echo '",
+	"| touch pwned",
+	"&& touch pwned",
+	"> pwned",
+	"*",
+	"~",
+	"-n",
+	"--",
+	"tab\there",
+	"ünïcödé",
+}
+
+func TestShellQuote(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, value := range hostileValues {
+		/// Given
+		cmd := exec.Command("sh", "-c", "printf '%s' "+shellQuote(value))
+		cmd.Dir = dir
+
+		/// When
+		out, err := cmd.Output()
+
+		/// Then
+		require.NoError(t, err, "value %q", value)
+		assert.Equal(t, value, string(out), "value %q should survive the shell unchanged", value)
+		assert.NoFileExists(t, path.Join(dir, "pwned"), "value %q should not execute anything", value)
+	}
+}
+
+// tailRun is a tail command running like the remote login shell would run it. The command runs in its own process
+// group so the tail it execs is stopped along with the shell.
+type tailRun struct {
+	cmd    *exec.Cmd
+	stdout statusRecorder
+	stderr statusRecorder
+}
+
+// startTailCommand starts the command in the directory, it's stopped at the end of the test if it isn't before.
+func startTailCommand(t *testing.T, dir string, cmd tailCommand, env ...string) *tailRun {
+	r := &tailRun{cmd: exec.Command("sh", "-c", cmd.String())}
+	r.cmd.Dir = dir
+	r.cmd.Env = append(os.Environ(), env...)
+	r.cmd.Stdout = &r.stdout
+	r.cmd.Stderr = &r.stderr
+	r.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, r.cmd.Start())
+	t.Cleanup(func() {
+		_, _ = r.stop()
+	})
+
+	return r
+}
+
+// waitFor waits until the command reported its position and its output ends with the given output, or gives up after
+// a while, leaving it to the test to tell what's missing.
+func (r *tailRun) waitFor(stdout string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.reported(stdout) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (r *tailRun) reported(stdout string) bool {
+	stderr := r.stderr.String()
+	i := strings.Index(stderr, positionMarker)
+	return i >= 0 && strings.Contains(stderr[i:], "\n") && strings.HasSuffix(r.stdout.String(), stdout)
+}
+
+// stop stops the command and returns its output.
+func (r *tailRun) stop() (string, string) {
+	_ = syscall.Kill(-r.cmd.Process.Pid, syscall.SIGKILL)
+	_ = r.cmd.Wait()
+
+	return r.stdout.String(), r.stderr.String()
+}
+
+// runTailCommand runs the command until its output ends with the given output.
+func runTailCommand(t *testing.T, dir string, cmd tailCommand, stdout string, env ...string) (string, string) {
+	r := startTailCommand(t, dir, cmd, env...)
+	r.waitFor(stdout)
+	return r.stop()
+}
+
+func TestTailCommand_HostilePaths(t *testing.T) {
+	for _, name := range hostileValues {
+		if name == "" || strings.ContainsAny(name, "/") {
+			continue
+		}
+
+		/// Given
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("first\nsecond\nthird\n"), 0644), "name %q", name)
+		cmd := tailCommand{path: name, follow: specfile.FollowName, lines: 2}
+
+		/// When
+		stdout, stderr := runTailCommand(t, dir, cmd, "second\nthird\n")
+
+		/// Then
+		assert.Equal(t, "second\nthird\n", stdout, "file %q should be tailed", name)
+		assert.Contains(t, stderr, positionMarker+" ", "file %q should report its position", name)
+		assert.NoFileExists(t, path.Join(dir, "pwned"), "file %q should not execute anything", name)
+
+		/// Given
+		home := t.TempDir()
+		require.NoError(t, os.WriteFile(path.Join(home, name), []byte("first\nin home\n"), 0644), "name %q", name)
+		cmd = tailCommand{path: "~/" + name, follow: specfile.FollowName, lines: 1}
+
+		/// When
+		stdout, _ = runTailCommand(t, dir, cmd, "in home\n", "HOME="+home)
+
+		/// Then
+		assert.Equal(t, "in home\n", stdout, "a leading ~/ should be the home directory for file %q", name)
+		assert.NoFileExists(t, path.Join(dir, "pwned"), "file %q should not execute anything", name)
+		assert.NoFileExists(t, path.Join(home, "pwned"), "file %q should not execute anything", name)
+	}
+}
+
+func TestFollowFlag(t *testing.T) {
+	assert.Equal(t, "-F", followFlag(specfile.FollowName))
+	assert.Equal(t, "-f", followFlag(specfile.FollowDescriptor))
+	assert.Equal(t, "-F", followFlag(""), "files should be followed by name by default")
+}
+
+func TestTailCommand_TruncatedInAnyLocale(t *testing.T) {
+	/// Given
+	dir := t.TempDir()
+	file := path.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("first\nsecond\n"), 0644))
+	cmd := tailCommand{path: file, follow: specfile.FollowName, lines: 10}
+
+	/// When
+	run := startTailCommand(t, dir, cmd, "LANG=de_DE.UTF-8", "LC_ALL=de_DE.UTF-8", "LC_MESSAGES=de_DE.UTF-8")
+	run.waitFor("first\nsecond\n")
+	require.NoError(t, os.WriteFile(file, []byte("new\n"), 0644))
+	run.waitFor("new\n")
+	stdout, stderr := run.stop()
+
+	pos := &sessionPosition{}
+	w := &stderrWriter{prefix: "web", path: file, pos: pos, status: io.Discard}
+	_, _ = positionWriter{pos: pos, next: io.Discard}.Write([]byte("first\nsecond\n"))
+	_, _ = w.Write([]byte(stderr))
+
+	/// Then
+	assert.Equal(t, "first\nsecond\nnew\n", stdout)
+	assert.Contains(t, stderr, "file truncated", "tail should report the truncation in English")
+	p, ok := pos.position()
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), p.offset, "the position should start over after the truncation")
+}
+
+func TestTailCommand_RemoteFilter(t *testing.T) {
+	/// Given
+	dir := t.TempDir()
+	file := path.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("INFO start\nERROR it's 'quoted'\nINFO ok\nerror again\nINFO done\n"), 0644))
+
+	filter := &specfile.FilterSpec{Include: []string{`error`, `'; touch pwned; '`}, IgnoreCase: true}
+	grep, ok := grepCommand(filter)
+	require.True(t, ok)
+
+	/// When
+	stdout, _ := runTailCommand(t, dir, tailCommand{path: file, follow: specfile.FollowName, lines: 4, grep: grep}, "error again\n")
+
+	pos := &sessionPosition{filtered: true}
+	pos.report("1", 11)
+	var out bytes.Buffer
+	_, err := (&offsetWriter{pos: pos, next: &out}).Write([]byte(stdout))
+	require.NoError(t, err)
+
+	/// Then
+	assert.Equal(t, "ERROR it's 'quoted'\nerror again\n", out.String())
+	p, ok := pos.position()
+	assert.True(t, ok)
+	assert.Equal(t, int64(len("INFO start\nERROR it's 'quoted'\nINFO ok\nerror again\n")), p.offset, "should be positioned after the last line that passed")
+	assert.NoFileExists(t, path.Join(dir, "pwned"))
+}
+
+func TestGrepCommand(t *testing.T) {
+	tests := map[string]struct {
+		filter   specfile.FilterSpec
+		expected string
+	}{
+		"include": {
+			filter:   specfile.FilterSpec{Include: []string{`a`, `b`}, IgnoreCase: true},
+			expected: `grep --line-buffered -b -i -E -e 'a' -e 'b'`,
+		},
+		"exclude": {
+			filter:   specfile.FilterSpec{Exclude: []string{`a`}},
+			expected: `grep --line-buffered -b -v -E -e 'a'`,
+		},
+		"include and exclude": {
+			filter:   specfile.FilterSpec{Include: []string{`a`}, Exclude: []string{`b`}},
+			expected: `grep --line-buffered -b -E -e 'a'`,
+		},
+		"inverted exclude": {
+			filter:   specfile.FilterSpec{Exclude: []string{`a`}, Invert: true},
+			expected: `grep --line-buffered -b -E -e 'a'`,
+		},
+		"inverted include and exclude": {
+			filter: specfile.FilterSpec{Include: []string{`a`}, Exclude: []string{`b`}, Invert: true},
+		},
+		"none": {},
+		"unportable": {
+			filter: specfile.FilterSpec{Include: []string{`took`, `\d{3} ms`}},
+		},
+	}
+	for name, tc := range tests {
+		grep, ok := grepCommand(&tc.filter)
+		assert.Equal(t, tc.expected != "", ok, name)
+		assert.Equal(t, tc.expected, grep, name)
+	}
+}
+
+func TestPortableERE(t *testing.T) {
+	tests := map[string]bool{
+		`ERROR|FATAL`:            true,
+		`^\[[0-9]{4}-[0-9]{2}\]`: true,
+		`a\.b\(c\)\\`:            true,
+		`[]a-z]+ [^]x]`:          true,
+		`[[:digit:]]{3} ms`:      true,
+		`(GET|POST) /api/`:       true,
+		`\d{3} ms`:               false,
+		`\D`:                     false,
+		`\S+`:                    false,
+		`\w`:                     false,
+		`\p{L}`:                  false,
+		`\pL`:                    false,
+		`(?i)error`:              false,
+		`(?:a|b)c`:               false,
+		`a.*?b`:                  false,
+		`a+?`:                    false,
+		`a??`:                    false,
+		`a{2,3}?`:                false,
+		`[\d]`:                   false,
+		`[.\]]`:                  false,
+		`[[:digit:]`:             false,
+		`trailing\`:              false,
+	}
+
+	for pattern, expected := range tests {
+		assert.Equal(t, expected, portableERE(pattern), "pattern %q", pattern)
+	}
+}
+
+func TestTailCommand_RemoteFilterPortable(t *testing.T) {
+	// The portable patterns have to pass the same lines through the grep on this host as they do locally.
+	lines := []string{"took 250 ms", "took 25 ms", "[2023-01-01] a.b(c)\\", "x]", "GET /api/users", "ünïcödé"}
+	patterns := []string{`[0-9]{3} ms`, `^\[[0-9]{4}-[0-9]{2}-[0-9]{2}\]`, `a\.b\(c\)`, `[]a-z]$`, `(GET|POST) /api/`, `[[:digit:]]{2} ms`}
+
+	for _, pattern := range patterns {
+		/// Given
+		require.True(t, portableERE(pattern), pattern)
+		filter := &specfile.FilterSpec{Include: []string{pattern}}
+		require.NoError(t, filter.Validate())
+		grep, ok := grepCommand(filter)
+		require.True(t, ok)
+
+		/// When
+		cmd := exec.Command("sh", "-c", grep)
+		cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+		out, _ := cmd.Output()
+
+		/// Then
+		var expected strings.Builder
+		offset := 0
+		for _, line := range lines {
+			if filter.Match([]byte(line)) {
+				fmt.Fprintf(&expected, "%d:%s\n", offset, line)
+			}
+			offset += len(line) + 1
+		}
+		assert.Equal(t, expected.String(), string(out), "pattern %q", pattern)
+	}
+}
+
+func TestOffsetWriter_SplitWrites(t *testing.T) {
+	/// Given
+	pos := &sessionPosition{filtered: true}
+	pos.report("1", 100)
+	var out bytes.Buffer
+	w := &offsetWriter{pos: pos, next: &out}
+
+	/// When
+	for _, chunk := range []string{"1", "2:first", " line\n4", "0:x:y\n"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+
+	/// Then
+	assert.Equal(t, "first line\nx:y\n", out.String())
+	p, _ := pos.position()
+	assert.Equal(t, int64(144), p.offset)
+
+	pos.restart(false)
+	_, ok := pos.position()
+	assert.False(t, ok, "the position of a filtered session is lost when tail starts over")
+}
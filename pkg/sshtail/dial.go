@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpoint holds the parameters needed to connect and authenticate to a single SSH server, which is either a host to
+// tail or one of its jump hosts.
+type endpoint struct {
+	tag            string
+	hostname       string
+	port           int
+	username       string
+	identityFile   string
+	auth           string
+	knownHostsFile string
+	hostKeyPolicy  string
+	// extraIdentityFiles are tried after the identity file.
+	extraIdentityFiles []string
+	// signers holds the keys loaded from identity files, so they're not read again when reconnecting.
+	signers *signerCache
+	// status is where messages about connecting, like host keys being added, are written.
+	status io.Writer
+}
+
+func hostEndpoint(hostTag string, host *specfile.HostSpec) endpoint {
+	return endpoint{
+		tag:                hostTag,
+		hostname:           host.Hostname,
+		port:               host.Port,
+		username:           host.Username,
+		identityFile:       host.IdentityFile,
+		extraIdentityFiles: host.ExtraIdentityFiles,
+		auth:               host.Auth,
+		knownHostsFile:     host.KnownHostsFile,
+		hostKeyPolicy:      host.HostKeyPolicy,
+	}
+}
+
+func jumpEndpoint(hostTag string, hop *specfile.JumpSpec) endpoint {
+	return endpoint{
+		tag:                fmt.Sprintf("%s (jump host %s)", hostTag, hop.Hostname),
+		hostname:           hop.Hostname,
+		port:               hop.Port,
+		username:           hop.Username,
+		identityFile:       hop.IdentityFile,
+		extraIdentityFiles: hop.ExtraIdentityFiles,
+		auth:               hop.Auth,
+		knownHostsFile:     hop.KnownHostsFile,
+		hostKeyPolicy:      hop.HostKeyPolicy,
+	}
+}
+
+// identityFiles returns all identity files to try, in order.
+func (e endpoint) identityFiles() []string {
+	return append([]string{e.identityFile}, e.extraIdentityFiles...)
+}
+
+func (e endpoint) addr() string {
+	return net.JoinHostPort(e.hostname, strconv.Itoa(e.port))
+}
+
+// key identifies the connection to the endpoint, so connections can be shared.
+func (e endpoint) key() string {
+	return e.username + "@" + e.addr()
+}
+
+// connectTimeout limits how long connecting to an endpoint, including the SSH handshake, may take.
+var connectTimeout = 30 * time.Second
+
+func noOpBanner(_ string) error { return nil }
+
+// dialEndpoint connects to the endpoint, either directly or by tunneling through an already connected jump host.
+func dialEndpoint(e endpoint, via *ssh.Client) (*ssh.Client, error) {
+	agentClient, agentConn, err := dialAgentFor(e)
+	if err != nil {
+		return nil, err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	auth, err := authMethods(e, agentClient)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := newHostKeyVerifier(e)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := e.addr()
+	config := &ssh.ClientConfig{
+		User:              e.username,
+		Auth:              auth,
+		BannerCallback:    noOpBanner,
+		HostKeyCallback:   verifier.check,
+		HostKeyAlgorithms: verifier.algorithms(addr),
+		Timeout:           connectTimeout,
+	}
+
+	var conn net.Conn
+	if via == nil {
+		conn, err = net.DialTimeout("tcp", addr, config.Timeout)
+	} else {
+		conn, err = dialTunnel(via, addr, config.Timeout)
+	}
+	if err != nil {
+		if via != nil {
+			return nil, fmt.Errorf("failed to open tunnel to %s: %v", addr, err)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+
+	return clientHandshake(conn, addr, config)
+}
+
+// dialTunnel opens a tunnel to the address through the jump host. A jump host that doesn't open the tunnel in time is
+// given up on, and the tunnel is closed if it opens after all.
+func dialTunnel(via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	type tunnel struct {
+		conn net.Conn
+		err  error
+	}
+
+	opened := make(chan tunnel, 1)
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		opened <- tunnel{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case t := <-opened:
+		return t.conn, t.err
+	case <-timer.C:
+		go func() {
+			if t := <-opened; t.conn != nil {
+				_ = t.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// clientHandshake sets up the SSH connection over an open connection. A handshake that takes longer than the timeout of the
+// config is given up on by closing the connection, which works for tunnels as well, unlike a deadline.
+func clientHandshake(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	timer := time.AfterFunc(config.Timeout, func() {
+		_ = conn.Close()
+	})
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !timer.Stop() {
+		if err == nil {
+			_ = sshConn.Close()
+		}
+		return nil, fmt.Errorf("failed to connect to %s: handshake timed out after %v", addr, config.Timeout)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// keepalive sends keepalive requests over the connection until one fails or goes unanswered for a whole interval,
+// then closes the connection and returns the reason.
+func keepalive(client *ssh.Client, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case err := <-reply:
+			if err != nil {
+				_ = client.Close()
+				return fmt.Errorf("keepalive failed: %v", err)
+			}
+		case <-time.After(interval):
+			_ = client.Close()
+			return errors.New("keepalive timed out")
+		}
+	}
+
+	return nil
+}
+
+// jumpPool shares jump host connections between all hosts that are reached through the same chain of jump hosts. The
+// keys loaded to connect to the hosts and their jump hosts are shared as well, and kept for reconnecting.
+type jumpPool struct {
+	mu      sync.Mutex
+	clients map[string]*jumpClient
+	closed  bool
+	signers *signerCache
+	status  io.Writer
+}
+
+// jumpClient is a pooled jump host connection. Hosts that need it while it's still connecting wait for it to be ready,
+// instead of connecting to the jump host themselves.
+type jumpClient struct {
+	ready  chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// newJumpPool creates a pool whose connections write messages about connecting to the given status output.
+func newJumpPool(status io.Writer) *jumpPool {
+	return &jumpPool{clients: map[string]*jumpClient{}, signers: newSignerCache(), status: status}
+}
+
+// dial connects to the host through its jump hosts, reusing jump host connections that are already open.
+func (p *jumpPool) dial(hostTag string, host *specfile.HostSpec) (*ssh.Client, error) {
+	interval := host.KeepaliveInterval
+	if interval <= 0 {
+		interval = specfile.DefaultKeepaliveInterval
+	}
+
+	via, err := p.viaClient(hostTag, host.Jump, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	e := hostEndpoint(hostTag, host)
+	e.signers = p.signers
+	e.status = p.status
+	return dialEndpoint(e, via)
+}
+
+// viaClient returns the connection to the last jump host of the chain, or nil if the chain is empty. Jump host
+// connections are kept alive, and dropped from the pool once they're lost so the next dial reconnects them.
+func (p *jumpPool) viaClient(hostTag string, hops []*specfile.JumpSpec, interval time.Duration) (*ssh.Client, error) {
+	var via *ssh.Client
+	keys := make([]string, 0, len(hops))
+	for _, hop := range hops {
+		e := jumpEndpoint(hostTag, hop)
+		e.signers = p.signers
+		e.status = p.status
+		keys = append(keys, e.key())
+
+		client, err := p.connect(strings.Join(keys, ","), e, via, interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", e.key(), err)
+		}
+		via = client
+	}
+
+	return via, nil
+}
+
+// connect returns the pooled connection for the chain, connecting to its last jump host if nobody did yet. The lock is
+// only held to look up the connection, so connecting to one jump host doesn't hold up the others.
+func (p *jumpPool) connect(chainKey string, e endpoint, via *ssh.Client, interval time.Duration) (*ssh.Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("jump host pool closed")
+	}
+
+	jc, ok := p.clients[chainKey]
+	if !ok {
+		jc = &jumpClient{ready: make(chan struct{})}
+		p.clients[chainKey] = jc
+	}
+	p.mu.Unlock()
+
+	if ok {
+		<-jc.ready
+		return jc.client, jc.err
+	}
+
+	jc.client, jc.err = dialEndpoint(e, via)
+	if jc.err != nil {
+		close(jc.ready)
+		// Drop the failed connection, so the next dial tries again.
+		p.remove(chainKey, jc)
+		return nil, jc.err
+	}
+
+	// The connection is only ready under the lock, so the pool either closes it or is closed before it's handed out.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = jc.client.Close()
+		jc.client, jc.err = nil, errors.New("jump host pool closed")
+		close(jc.ready)
+		return nil, jc.err
+	}
+
+	close(jc.ready)
+	go p.watch(chainKey, jc, interval)
+	return jc.client, nil
+}
+
+func (p *jumpPool) watch(chainKey string, jc *jumpClient, interval time.Duration) {
+	go func() {
+		_ = keepalive(jc.client, interval)
+	}()
+
+	_ = jc.client.Wait()
+	p.remove(chainKey, jc)
+}
+
+func (p *jumpPool) remove(chainKey string, jc *jumpClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.clients[chainKey] == jc {
+		delete(p.clients, chainKey)
+	}
+}
+
+// Close closes all jump host connections, the connections tunneled through them should be closed first.
+func (p *jumpPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	// Longer chains are tunneled through shorter ones, so close them first.
+	keys := make([]string, 0, len(p.clients))
+	for key := range p.clients {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Count(keys[i], ",") > strings.Count(keys[j], ",")
+	})
+
+	for _, key := range keys {
+		// Connections that are still being set up are closed once they're done.
+		select {
+		case <-p.clients[key].ready:
+			if client := p.clients[key].client; client != nil {
+				_ = client.Close()
+			}
+		default:
+		}
+		delete(p.clients, key)
+	}
+
+	return nil
+}
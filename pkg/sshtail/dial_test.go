@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// silentServer accepts connections but never answers, like a host whose SSH server hangs. Every accepted connection is
+// announced on the returned channel.
+func silentServer(t *testing.T) (int, <-chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	accepted := make(chan net.Conn, 16)
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			accepted <- conn
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, accepted
+}
+
+// setConnectTimeout lowers the connect timeout for the duration of the test.
+func setConnectTimeout(t *testing.T, timeout time.Duration) {
+	previous := connectTimeout
+	connectTimeout = timeout
+	t.Cleanup(func() {
+		connectTimeout = previous
+	})
+}
+
+func TestJumpPool_SharesChains(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	identityFile := writeKeyFile(t, key)
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+
+	jump1 := newTestServer(t, generateKey(t), key)
+	jump2 := newTestServer(t, generateKey(t), key)
+	web1 := newTestServer(t, generateKey(t), key)
+	web2 := newTestServer(t, generateKey(t), key)
+	db := newTestServer(t, generateKey(t), key)
+
+	hop := func(s *testServer) *specfile.JumpSpec {
+		return &specfile.JumpSpec{Hostname: "127.0.0.1", Port: s.port(), Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}
+	}
+	host := func(s *testServer, hops ...*specfile.JumpSpec) *specfile.HostSpec {
+		return &specfile.HostSpec{Hostname: "127.0.0.1", Port: s.port(), Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, Jump: hops}
+	}
+
+	jumps := newJumpPool(io.Discard)
+	defer jumps.Close()
+
+	/// When
+	for tag, h := range map[string]*specfile.HostSpec{
+		"web1": host(web1, hop(jump1)),
+		"web2": host(web2, hop(jump1)),
+		"db":   host(db, hop(jump1), hop(jump2)),
+	} {
+		client, err := jumps.dial(tag, h)
+		require.NoError(t, err, "host %s should be reached through its jump hosts", tag)
+		defer client.Close()
+	}
+
+	/// Then
+	assert.Equal(t, 1, jump1.connections(), "hosts behind the same jump host should share its connection")
+	assert.Equal(t, 1, jump2.connections(), "the second jump host should be connected once")
+
+	key1 := "test@127.0.0.1:" + strconv.Itoa(jump1.port())
+	key2 := key1 + ",test@127.0.0.1:" + strconv.Itoa(jump2.port())
+	jumps.mu.Lock()
+	defer jumps.mu.Unlock()
+	assert.Len(t, jumps.clients, 2)
+	assert.Contains(t, jumps.clients, key1)
+	assert.Contains(t, jumps.clients, key2, "a chain should be keyed by all of its jump hosts")
+}
+
+func TestJumpPool_DialFailure(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	identityFile := writeKeyFile(t, key)
+	target := newTestServer(t, generateKey(t), key)
+
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: target.port(), Username: "test", IdentityFile: identityFile,
+		Auth: specfile.AuthFile, KnownHostsFile: path.Join(t.TempDir(), "known_hosts"), HostKeyPolicy: specfile.HostKeyPolicyAcceptNew,
+		Jump: []*specfile.JumpSpec{{Hostname: "127.0.0.1", Port: 1, Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: path.Join(t.TempDir(), "known_hosts"), HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}}}
+
+	jumps := newJumpPool(io.Discard)
+	defer jumps.Close()
+
+	/// When
+	_, err := jumps.dial("web", host)
+
+	/// Then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jump host test@127.0.0.1:1")
+	assert.Empty(t, jumps.clients, "a failed jump host should not be pooled")
+}
+
+func TestDialEndpoint_HandshakeTimeout(t *testing.T) {
+	/// Given
+	setConnectTimeout(t, 100*time.Millisecond)
+	port, _ := silentServer(t)
+	e := endpoint{tag: "web", hostname: "127.0.0.1", port: port, username: "test", identityFile: writeKeyFile(t, generateKey(t)),
+		auth: specfile.AuthFile, knownHostsFile: path.Join(t.TempDir(), "known_hosts"), hostKeyPolicy: specfile.HostKeyPolicyAcceptNew}
+
+	/// When
+	started := time.Now()
+	_, err := dialEndpoint(e, nil)
+
+	/// Then
+	require.Error(t, err, "a server that never answers should not be waited for forever")
+	assert.Contains(t, err.Error(), "handshake timed out")
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+}
+
+func TestDialEndpoint_TunnelTimeout(t *testing.T) {
+	/// Given
+	setConnectTimeout(t, 100*time.Millisecond)
+	key := generateKey(t)
+	identityFile := writeKeyFile(t, key)
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+	jump := newTestServer(t, generateKey(t), key)
+	jump.hangNewTunnels()
+	web := newTestServer(t, generateKey(t), key)
+
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: web.port(), Username: "test", IdentityFile: identityFile,
+		Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew,
+		Jump: []*specfile.JumpSpec{{Hostname: "127.0.0.1", Port: jump.port(), Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}}}
+	jumps := newJumpPool(io.Discard)
+	defer jumps.Close()
+
+	/// When
+	started := time.Now()
+	_, err := jumps.dial("web", host)
+
+	/// Then
+	require.Error(t, err, "a jump host that never opens the tunnel should not be waited for forever")
+	assert.Contains(t, err.Error(), "failed to open tunnel")
+	assert.Contains(t, err.Error(), "timed out")
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+	assert.Equal(t, 0, web.connections(), "the host should not be reached through the hung tunnel")
+}
+
+func TestJumpPool_HungJumpHostDoesNotBlockOthers(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	identityFile := writeKeyFile(t, key)
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+	hungPort, accepted := silentServer(t)
+	jump := newTestServer(t, generateKey(t), key)
+	web := newTestServer(t, generateKey(t), key)
+	db := newTestServer(t, generateKey(t), key)
+
+	host := func(s *testServer, jumpPort int) *specfile.HostSpec {
+		h := &specfile.HostSpec{Hostname: "127.0.0.1", Port: s.port(), Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}
+		if jumpPort != 0 {
+			h.Jump = []*specfile.JumpSpec{{Hostname: "127.0.0.1", Port: jumpPort, Username: "test", IdentityFile: identityFile,
+				Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}}
+		}
+		return h
+	}
+
+	jumps := newJumpPool(io.Discard)
+	defer jumps.Close()
+
+	hung := make(chan error, 1)
+	go func() {
+		_, err := jumps.dial("hung", host(web, hungPort))
+		hung <- err
+	}()
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the hung jump host was never connected to")
+	}
+
+	/// When
+	direct := make(chan error, 2)
+	for tag, h := range map[string]*specfile.HostSpec{"web": host(web, 0), "db": host(db, jump.port())} {
+		go func(tag string, h *specfile.HostSpec) {
+			client, err := jumps.dial(tag, h)
+			if err == nil {
+				_ = client.Close()
+			}
+			direct <- err
+		}(tag, h)
+	}
+
+	/// Then
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-direct:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "hosts should be reached while another jump host hangs")
+		}
+	}
+
+	select {
+	case err := <-hung:
+		require.FailNow(t, "the hung jump host should still be connecting", "error: %v", err)
+	default:
+	}
+}
+
+func TestJumpPool_ConnectsJumpHostOnce(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	identityFile := writeKeyFile(t, key)
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+	jump := newTestServer(t, generateKey(t), key)
+	jumps := newJumpPool(io.Discard)
+	defer jumps.Close()
+
+	/// When
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		target := newTestServer(t, generateKey(t), key)
+		host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: target.port(), Username: "test", IdentityFile: identityFile,
+			Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew,
+			Jump: []*specfile.JumpSpec{{Hostname: "127.0.0.1", Port: jump.port(), Username: "test", IdentityFile: identityFile,
+				Auth: specfile.AuthFile, KnownHostsFile: knownHostsFile, HostKeyPolicy: specfile.HostKeyPolicyAcceptNew}}}
+
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			client, err := jumps.dial(tag, host)
+			if err == nil {
+				_ = client.Close()
+			}
+			errs <- err
+		}("web" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+
+	/// Then
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, jump.connections(), "hosts dialing at the same time should share the jump host connection")
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import "time"
+
+// LogEvent is a single line tailed from a file on a host.
+type LogEvent struct {
+	// Tag is the tag of the host in the spec.
+	Tag string
+	// Hostname is the host the line was tailed from.
+	Hostname string
+	// File is the path of the file on the host.
+	File string
+	// FileTag is the tag of the file, if the host tails more than one file or the file's tag is configured. Otherwise
+	// the host tag alone tells where the line came from.
+	FileTag string
+	// Line is the line without its trailing newline. If the host groups lines into records, it holds all lines of the
+	// record, separated by newlines.
+	Line []byte
+	// Received is when the line was received.
+	Received time.Time
+	// Timestamp is when the line was logged, if the output is ordered by it. Lines without a timestamp of their own
+	// have the one of the line before them.
+	Timestamp time.Time
+	// Late is set if the output is ordered, but the line arrived after a line logged later was already passed on.
+	Late bool
+	// Seq numbers the events that passed the filters in the order they're passed on across all hosts, starting at 1.
+	Seq uint64
+}
+
+// Source identifies where the line came from, which is the host tag, followed by the file tag if the host tails more
+// than one file.
+func (e LogEvent) Source() string {
+	if e.FileTag == "" {
+		return e.Tag
+	}
+	return e.Tag + "/" + e.FileTag
+}
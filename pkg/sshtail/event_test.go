@@ -0,0 +1,28 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogEvent_Source(t *testing.T) {
+	assert.Equal(t, "web", LogEvent{Tag: "web", File: "/var/log/syslog"}.Source(), "a single file should only show the host tag")
+	assert.Equal(t, "web/errors", LogEvent{Tag: "web", File: "/var/log/nginx/error.log", FileTag: "errors"}.Source())
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Formatter turns an event into the bytes written to the output, including the trailing newline.
+type Formatter interface {
+	Format(e LogEvent) ([]byte, error)
+}
+
+// lateMarker is shown in front of lines that arrived too late to be ordered.
+const lateMarker = "(late) "
+
+// TextFormatter formats events as the line prefixed with where it came from, marking lines that arrived too late to
+// be ordered. The prefix is painted in the color of the host if Colors is set, and matches within the line are painted
+// if Highlight is set.
+type TextFormatter struct {
+	Colors    *HostColors
+	Highlight *Highlighter
+}
+
+func (f TextFormatter) Format(e LogEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(e.Tag) + len(e.FileTag) + len(e.Line) + 16)
+	buf.WriteString(f.Colors.Paint(e.Tag, e.Source()))
+	buf.WriteString(" | ")
+	if e.Late {
+		buf.WriteString(lateMarker)
+	}
+	buf.Write(f.Highlight.Highlight(e.Line))
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// JSONFormatter formats every event as a JSON object on a line of its own. JSON strings can't hold invalid UTF-8, so
+// invalid bytes in the message are replaced by U+FFFD, and the original line is added encoded as base64.
+type JSONFormatter struct{}
+
+type jsonEvent struct {
+	Tag           string    `json:"tag"`
+	Hostname      string    `json:"hostname"`
+	File          string    `json:"file"`
+	FileTag       string    `json:"file_tag,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	Seq           uint64    `json:"seq"`
+	Late          bool      `json:"late,omitempty"`
+	Message       string    `json:"message"`
+	MessageBase64 []byte    `json:"message_base64,omitempty"`
+}
+
+func (JSONFormatter) Format(e LogEvent) ([]byte, error) {
+	je := jsonEvent{
+		Tag:       e.Tag,
+		Hostname:  e.Hostname,
+		File:      e.File,
+		FileTag:   e.FileTag,
+		Timestamp: e.Received,
+		Seq:       e.Seq,
+		Late:      e.Late,
+		Message:   string(e.Line),
+	}
+	if !utf8.Valid(e.Line) {
+		je.Message = string(bytes.ToValidUTF8(e.Line, []byte(string(utf8.RuneError))))
+		je.MessageBase64 = e.Line
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(je); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatPresets are the named formats accepted by NewTemplateFormatter.
+var formatPresets = map[string]string{
+	"short": `{{.Color .Source}} | {{if .Late}}(late) {{end}}{{.Line}}`,
+	"long":  `{{.Received.Format "2006-01-02T15:04:05.000Z07:00"}} {{.Host}} {{.Color .Source}} {{.File}} | {{if .Late}}(late) {{end}}{{.Line}}`,
+	"raw":   `{{.Line}}`,
+}
+
+// templateEvent is what a format template is executed with.
+type templateEvent struct {
+	Tag       string
+	Host      string
+	File      string
+	FileTag   string
+	Source    string
+	Line      string
+	Received  time.Time
+	Timestamp time.Time
+	Late      bool
+	Seq       uint64
+
+	colors *HostColors
+}
+
+// Color paints the text in the color of the host.
+func (e templateEvent) Color(text string) string {
+	return e.colors.Paint(e.Tag, text)
+}
+
+// TemplateFormatter formats events with a text/template. The template has the fields Tag, Host, File, FileTag, Source,
+// Line, Received, Timestamp, Late and Seq of the event, and every line it produces is followed by a newline. The Color method paints
+// text in the color of the host of the event, and Line has its matches painted if there is a highlighter.
+type TemplateFormatter struct {
+	tmpl      *template.Template
+	colors    *HostColors
+	highlight *Highlighter
+}
+
+// NewTemplateFormatter parses a format, which is either the name of a preset (short, long or raw) or a template.
+// Colors and highlight may be nil for output without colors.
+func NewTemplateFormatter(format string, colors *HostColors, highlight *Highlighter) (*TemplateFormatter, error) {
+	if preset, ok := formatPresets[format]; ok {
+		format = preset
+	}
+
+	tmpl, err := template.New("format").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format: %w", err)
+	}
+
+	// Fields that don't exist are only noticed when executing, so the template is tried once instead of failing on
+	// every line later on.
+	if err = tmpl.Execute(io.Discard, templateEvent{colors: colors}); err != nil {
+		return nil, fmt.Errorf("invalid format: %w", err)
+	}
+
+	return &TemplateFormatter{tmpl: tmpl, colors: colors, highlight: highlight}, nil
+}
+
+func (f *TemplateFormatter) Format(e LogEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	err := f.tmpl.Execute(&buf, templateEvent{
+		Tag:       e.Tag,
+		Host:      e.Hostname,
+		File:      e.File,
+		FileTag:   e.FileTag,
+		Source:    e.Source(),
+		Line:      string(f.highlight.Highlight(e.Line)),
+		Received:  e.Received,
+		Timestamp: e.Timestamp,
+		Late:      e.Late,
+		Seq:       e.Seq,
+		colors:    f.colors,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	/// Given
+	received := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	e := LogEvent{Tag: "web", Hostname: "web-1", File: "/var/log/app.log", Line: []byte(`a | "b" <c>`), Received: received, Seq: 3}
+
+	/// When
+	b, err := JSONFormatter{}.Format(e)
+
+	/// Then
+	require.NoError(t, err)
+	assert.Equal(t, `{"tag":"web","hostname":"web-1","file":"/var/log/app.log","timestamp":"2023-04-05T06:07:08Z","seq":3,"message":"a | \"b\" <c>"}`+"\n", string(b))
+}
+
+func TestJSONFormatter_InvalidUTF8(t *testing.T) {
+	/// Given
+	line := []byte("bad \xff\xfe byte")
+	e := LogEvent{Tag: "web", Line: line}
+
+	/// When
+	b, err := JSONFormatter{}.Format(e)
+
+	/// Then
+	require.NoError(t, err)
+
+	var decoded struct {
+		Message       string `json:"message"`
+		MessageBase64 []byte `json:"message_base64"`
+	}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, "bad � byte", decoded.Message)
+	assert.Equal(t, line, decoded.MessageBase64)
+}
+
+func TestTemplateFormatter(t *testing.T) {
+	e := LogEvent{
+		Tag:      "web",
+		Hostname: "web-1",
+		File:     "/var/log/app.log",
+		FileTag:  "app",
+		Line:     []byte("started"),
+		Received: time.Date(2023, 4, 5, 6, 7, 8, 9000000, time.UTC),
+		Seq:      42,
+	}
+
+	tests := map[string]string{
+		"short":                            "web/app | started\n",
+		"long":                             "2023-04-05T06:07:08.009Z web-1 web/app /var/log/app.log | started\n",
+		"raw":                              "started\n",
+		`{{printf "%-6s" .Tag}}|{{.Line}}`: "web   |started\n",
+		`{{.Seq}} {{.Host}}:{{.File}}`:     "42 web-1:/var/log/app.log\n",
+		`{{.Received.Format "15:04:05"}}`:  "06:07:08\n",
+	}
+	for format, expected := range tests {
+		/// Given
+		f, err := NewTemplateFormatter(format, nil, nil)
+		require.NoError(t, err, format)
+
+		/// When
+		b, err := f.Format(e)
+
+		/// Then
+		require.NoError(t, err, format)
+		assert.Equal(t, expected, string(b), format)
+	}
+}
+
+func TestTemplateFormatter_Invalid(t *testing.T) {
+	_, err := NewTemplateFormatter("{{.Line", nil, nil)
+	assert.Error(t, err)
+
+	_, err = NewTemplateFormatter("{{.Hots}}", nil, nil)
+	assert.Error(t, err, "unknown fields should be rejected up front")
+
+	_, err = NewTemplateFormatter("{{.Color}}", nil, nil)
+	assert.Error(t, err, "wrong arguments should be rejected up front")
+
+	for preset := range formatPresets {
+		_, err = NewTemplateFormatter(preset, nil, nil)
+		assert.NoError(t, err, preset)
+	}
+}
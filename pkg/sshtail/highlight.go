@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// highlightColors are assigned to the highlight patterns in order, and repeat if there are more patterns. They're
+// bold to stand out from the host colors.
+var highlightColors = []string{"1;31", "1;32", "1;33", "1;34", "1;35", "1;36"}
+
+// Highlighter paints the matches of patterns within a line, every pattern in a color of its own.
+type Highlighter struct {
+	patterns []*regexp.Regexp
+}
+
+// NewHighlighter compiles the patterns to highlight.
+func NewHighlighter(patterns []string) (*Highlighter, error) {
+	h := &Highlighter{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid highlight pattern '%s': %w", p, err)
+		}
+		h.patterns = append(h.patterns, re)
+	}
+
+	return h, nil
+}
+
+// Highlight returns the line with the matches of the patterns painted. Where matches of different patterns overlap,
+// the pattern given first wins. A nil Highlighter returns the line as it is.
+func (h *Highlighter) Highlight(line []byte) []byte {
+	if h == nil || len(h.patterns) == 0 {
+		return line
+	}
+
+	// colors holds the index of the pattern painting each byte, plus one.
+	colors := make([]int, len(line))
+	matched := false
+	for i, re := range h.patterns {
+		for _, m := range re.FindAllIndex(line, -1) {
+			for j := m[0]; j < m[1]; j++ {
+				if colors[j] == 0 {
+					colors[j] = i + 1
+					matched = true
+				}
+			}
+		}
+	}
+	if !matched {
+		return line
+	}
+
+	var buf bytes.Buffer
+	for start := 0; start < len(line); {
+		end := start
+		for end < len(line) && colors[end] == colors[start] {
+			end++
+		}
+
+		if c := colors[start]; c == 0 {
+			buf.Write(line[start:end])
+		} else {
+			buf.WriteString("\x1b[" + highlightColors[(c-1)%len(highlightColors)] + "m")
+			buf.Write(line[start:end])
+			buf.WriteString("\x1b[0m")
+		}
+		start = end
+	}
+
+	return buf.Bytes()
+}
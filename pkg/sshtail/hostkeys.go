@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// knownHostsMu serializes writes to known_hosts files shared between hosts.
+var knownHostsMu sync.Mutex
+
+// hostKeyVerifier checks server host keys against a known_hosts file according to the host key policy.
+type hostKeyVerifier struct {
+	tag      string
+	file     string
+	policy   string
+	callback ssh.HostKeyCallback
+	status   io.Writer
+}
+
+// hostKeyAlgorithms is the order in which host key algorithms are negotiated, limited to the key types known_hosts
+// holds for a host.
+var hostKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+	ssh.KeyAlgoDSA,
+}
+
+// newHostKeyVerifier creates a host key verifier for the given endpoint, reading its known_hosts file.
+func newHostKeyVerifier(e endpoint) (*hostKeyVerifier, error) {
+	if e.knownHostsFile == "" {
+		return nil, fmt.Errorf("no known_hosts file configured for host %s", e.tag)
+	}
+
+	policy := e.hostKeyPolicy
+	if policy == "" {
+		policy = specfile.HostKeyPolicyStrict
+	}
+
+	if policy == specfile.HostKeyPolicyAcceptNew {
+		if err := ensureKnownHostsFile(e.knownHostsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	callback, err := knownhosts.New(e.knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts file %s: %w", e.knownHostsFile, err)
+	}
+
+	verifier := &hostKeyVerifier{
+		tag:      e.tag,
+		file:     e.knownHostsFile,
+		policy:   policy,
+		callback: callback,
+		status:   e.status,
+	}
+	return verifier, nil
+}
+
+// algorithms returns the host key algorithms to negotiate with the host, so it presents a key of a type known_hosts
+// already holds for it instead of the one preferred by default. It returns nil for a host without known keys.
+func (v *hostKeyVerifier) algorithms(hostname string) []string {
+	// The lookup needs a key that is not known for the host, so known_hosts reports all keys it holds for it instead.
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := v.callback(hostname, &net.TCPAddr{IP: net.IPv4zero}, probe); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, want := range keyErr.Want {
+		known[want.Key.Type()] = true
+	}
+
+	var algorithms []string
+	for _, algorithm := range hostKeyAlgorithms {
+		keyType := algorithm
+		if algorithm == ssh.KeyAlgoRSASHA512 || algorithm == ssh.KeyAlgoRSASHA256 {
+			keyType = ssh.KeyAlgoRSA
+		}
+		if known[keyType] {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+func (v *hostKeyVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	err := v.callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key verification failed for %s (%s): %w", v.tag, hostname, err)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	// Only a different key of the same type means the key has changed, a host can have a key of every type.
+	for _, want := range keyErr.Want {
+		if want.Key.Type() != key.Type() {
+			continue
+		}
+		return fmt.Errorf("host key for %s (%s) has changed: got %s %s, but %s:%d expects %s %s",
+			v.tag, hostname, key.Type(), fingerprint, want.Filename, want.Line, want.Key.Type(), ssh.FingerprintSHA256(want.Key))
+	}
+
+	if v.policy != specfile.HostKeyPolicyAcceptNew {
+		return fmt.Errorf("host key for %s (%s) is unknown: %s %s is not in %s", v.tag, hostname, key.Type(), fingerprint, v.file)
+	}
+
+	if err := appendKnownHost(v.file, hostname, key); err != nil {
+		return fmt.Errorf("failed to add host key for %s (%s): %w", v.tag, hostname, err)
+	}
+
+	_, _ = fmt.Fprintf(v.status, "Added %s host key %s for %s (%s) to %s\n", key.Type(), fingerprint, v.tag, hostname, v.file)
+	return nil
+}
+
+// ensureKnownHostsFile creates an empty known_hosts file, and its directory, if it doesn't exist yet.
+func ensureKnownHostsFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for known_hosts file %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create known_hosts file %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+func appendKnownHost(path string, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err = fmt.Fprintln(f, line); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHostKeys_StrictRefusesUnknownKey(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsFile, nil, 0600))
+	e := server.endpoint(writeKeyFile(t, key), knownHostsFile, specfile.HostKeyPolicyStrict)
+
+	/// When
+	_, err := dialEndpoint(e, nil)
+
+	/// Then
+	require.Error(t, err, "an unknown host key should be refused")
+	assert.Contains(t, err.Error(), "is unknown")
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(server.hostKey))
+
+	contents, err := os.ReadFile(knownHostsFile)
+	require.NoError(t, err)
+	assert.Empty(t, contents, "the strict policy should not add keys")
+}
+
+func TestHostKeys_AcceptNewAddsKeyOnce(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	knownHostsFile := path.Join(t.TempDir(), ".ssh", "known_hosts")
+	e := server.endpoint(writeKeyFile(t, key), knownHostsFile, specfile.HostKeyPolicyAcceptNew)
+	status := &statusRecorder{}
+	e.status = status
+
+	/// When
+	for i := 0; i < 2; i++ {
+		client, err := dialEndpoint(e, nil)
+		require.NoError(t, err, "connection %d should be accepted", i+1)
+		_ = client.Close()
+	}
+
+	/// Then
+	contents, err := os.ReadFile(knownHostsFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 1, "the key should be added exactly once")
+
+	hostname := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port())))
+	assert.Equal(t, knownhosts.Line([]string{hostname}, server.hostKey), lines[0])
+	assert.Equal(t, 1, strings.Count(status.String(), "Added"), "adding the key should be reported once: %s", status)
+	assert.Contains(t, status.String(), ssh.FingerprintSHA256(server.hostKey))
+}
+
+func TestHostKeys_ChangedKeyFails(t *testing.T) {
+	for _, policy := range []string{specfile.HostKeyPolicyStrict, specfile.HostKeyPolicyAcceptNew} {
+		/// Given
+		key := generateKey(t)
+		server := newTestServer(t, generateKey(t), key)
+		oldKey, err := ssh.NewPublicKey(generateKey(t).Public())
+		require.NoError(t, err)
+
+		hostname := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port())))
+		knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+		require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{hostname}, oldKey)+"\n"), 0600))
+		e := server.endpoint(writeKeyFile(t, key), knownHostsFile, policy)
+
+		/// When
+		_, err = dialEndpoint(e, nil)
+
+		/// Then
+		require.Error(t, err, "a changed host key should be refused with policy %s", policy)
+		assert.Contains(t, err.Error(), "has changed", policy)
+		assert.Contains(t, err.Error(), e.tag, policy)
+		assert.Contains(t, err.Error(), ssh.FingerprintSHA256(server.hostKey), policy)
+		assert.Contains(t, err.Error(), ssh.FingerprintSHA256(oldKey), policy)
+
+		contents, err := os.ReadFile(knownHostsFile)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(contents), "\n"), "the known key should not be replaced with policy %s", policy)
+	}
+}
+
+func TestHostKeys_NegotiatesKnownKeyType(t *testing.T) {
+	for _, policy := range []string{specfile.HostKeyPolicyStrict, specfile.HostKeyPolicyAcceptNew} {
+		/// Given
+		key := generateKey(t)
+		server := newTestServer(t, generateKey(t), key)
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		server.addHostKey(t, ecdsaKey)
+
+		// Only the ed25519 key is known, like OpenSSH often writes it, while ECDSA is preferred by default.
+		hostname := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port())))
+		knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+		knownLine := knownhosts.Line([]string{hostname}, server.hostKey) + "\n"
+		require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownLine), 0600))
+		e := server.endpoint(writeKeyFile(t, key), knownHostsFile, policy)
+
+		/// When
+		client, err := dialEndpoint(e, nil)
+
+		/// Then
+		require.NoError(t, err, "the known ed25519 key should be negotiated with policy %s", policy)
+		_ = client.Close()
+
+		contents, err := os.ReadFile(knownHostsFile)
+		require.NoError(t, err)
+		assert.Equal(t, knownLine, string(contents), "no key should be added with policy %s", policy)
+	}
+}
+
+func TestHostKeys_OtherKeyTypeIsNotChanged(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	knownKey, err := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+	require.NoError(t, err)
+
+	hostname := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port())))
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{hostname}, knownKey)+"\n"), 0600))
+	e := server.endpoint(writeKeyFile(t, key), knownHostsFile, specfile.HostKeyPolicyStrict)
+	verifier, err := newHostKeyVerifier(e)
+	require.NoError(t, err)
+
+	/// When
+	err = verifier.check(e.addr(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.port()}, server.hostKey)
+
+	/// Then
+	require.Error(t, err, "a key of a type that isn't known should be refused by the strict policy")
+	assert.Contains(t, err.Error(), "is unknown")
+	assert.NotContains(t, err.Error(), "has changed")
+	assert.Equal(t, []string{ssh.KeyAlgoECDSA256}, verifier.algorithms(e.addr()))
+}
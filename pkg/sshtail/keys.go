@@ -22,11 +22,68 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
+	"sync"
 	"syscall"
 )
 
 // LoadKey reads a key from file
 func LoadKey(path string) (ssh.AuthMethod, error) {
+	signer, err := loadSigner(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+// signerCache keeps the keys loaded from identity files, so every file is only read, and the passphrase of an encrypted
+// key only asked for, when connecting the first time instead of on every reconnect.
+type signerCache struct {
+	mu      sync.Mutex
+	signers map[string]ssh.Signer
+}
+
+func newSignerCache() *signerCache {
+	return &signerCache{signers: map[string]ssh.Signer{}}
+}
+
+// load returns the key in the identity file, reading it if it wasn't loaded before. Without a cache, the key is read
+// every time.
+func (c *signerCache) load(path string) (ssh.Signer, error) {
+	if c == nil {
+		return loadSigner(path)
+	}
+
+	// The lock is held while reading, so the passphrase of a key shared by several hosts is only asked for once.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if signer, ok := c.signers[path]; ok {
+		return signer, nil
+	}
+
+	signer, err := loadSigner(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.signers[path] = signer
+	return signer, nil
+}
+
+// loadPublicKey reads a public key in authorized_keys format from file.
+func loadPublicKey(path string) (ssh.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	return key, err
+}
+
+// loadSigner reads a private key from file, asking for the passphrase if the key is encrypted.
+func loadSigner(path string) (ssh.Signer, error) {
 	key, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -54,5 +111,5 @@ func LoadKey(path string) (ssh.AuthMethod, error) {
 		fmt.Println("Key decrypted")
 	}
 
-	return ssh.PublicKeys(signer), nil
+	return signer, nil
 }
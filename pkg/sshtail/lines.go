@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLineLength is the longest line passed on in one piece, longer lines are split.
+	maxLineLength = 64 * 1024
+	// partialLineTimeout is how long the last line of the output may go without a newline before it's passed on anyway.
+	partialLineTimeout = 500 * time.Millisecond
+)
+
+// lineWriter splits the output of a session into lines, so every line is written to the next writer by itself. The
+// SSH channel delivers whatever chunks the remote side wrote, which may hold several lines or end in the middle of
+// one. A partial line is held back until the rest of it arrives, it has been waiting for longer than the timeout, or
+// the writer is closed. Lines written on are always terminated by a newline.
+type lineWriter struct {
+	next    io.Writer
+	max     int
+	timeout time.Duration
+
+	mu     sync.Mutex
+	buf    []byte
+	timer  *time.Timer
+	closed bool
+}
+
+func newLineWriter(next io.Writer) *lineWriter {
+	return &lineWriter{next: next, max: maxLineLength, timeout: partialLineTimeout}
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 || i >= w.max {
+			if len(w.buf) < w.max {
+				break
+			}
+			// Too long to wait for the end of the line, so it's cut at the limit.
+			i = w.max - 1
+		}
+
+		if err := w.emit(w.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+
+	w.schedule()
+	return len(b), nil
+}
+
+// schedule starts waiting for the rest of a partial line if there is one, the caller must hold the lock.
+func (w *lineWriter) schedule() {
+	if len(w.buf) == 0 {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		return
+	}
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.timeout, w.timedOut)
+	} else {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+func (w *lineWriter) timedOut() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_ = w.flush()
+}
+
+// flush passes on the partial line, if there is one, the caller must hold the lock.
+func (w *lineWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.emit(w.buf)
+	w.buf = nil
+	return err
+}
+
+// emit writes a single line, adding the newline if it doesn't have one. The line is copied, since the next writer
+// may hold on to it. The caller must hold the lock.
+func (w *lineWriter) emit(line []byte) error {
+	out := make([]byte, len(line), len(line)+1)
+	copy(out, line)
+	if out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+
+	_, err := w.next.Write(out)
+	return err
+}
+
+// Close passes on the partial line, if there is one. Nothing can be written afterwards.
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	return w.flush()
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineRecorder collects every write as a separate line.
+type lineRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *lineRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines = append(r.lines, string(b))
+	return len(b), nil
+}
+
+func (r *lineRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.lines...)
+}
+
+func TestLineWriter_Framing(t *testing.T) {
+	/// Given
+	rec := &lineRecorder{}
+	w := newLineWriter(rec)
+	w.timeout = time.Hour
+
+	/// When
+	for _, chunk := range []string{"first li", "ne\nsecond line\nthird", " line\n", "fourth"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+
+	/// Then
+	assert.Equal(t, []string{"first line\n", "second line\n", "third line\n"}, rec.get())
+
+	require.NoError(t, w.Close())
+	assert.Equal(t, []string{"first line\n", "second line\n", "third line\n", "fourth\n"}, rec.get())
+}
+
+func TestLineWriter_PartialLineTimeout(t *testing.T) {
+	/// Given
+	rec := &lineRecorder{}
+	w := newLineWriter(rec)
+	w.timeout = 10 * time.Millisecond
+
+	/// When
+	_, err := w.Write([]byte("done\nprompt> "))
+	require.NoError(t, err)
+
+	/// Then
+	deadline := time.Now().Add(time.Second)
+	for len(rec.get()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"done\n", "prompt> \n"}, rec.get())
+
+	_, err = w.Write([]byte("rest\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"done\n", "prompt> \n", "rest\n"}, rec.get())
+}
+
+func TestLineWriter_MaxLength(t *testing.T) {
+	/// Given
+	rec := &lineRecorder{}
+	w := newLineWriter(rec)
+	w.max = 4
+
+	/// When
+	_, err := w.Write([]byte("abcdefghij\nabc\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	/// Then
+	assert.Equal(t, []string{"abcd\n", "efgh\n", "ij\n", "abc\n"}, rec.get())
+}
+
+func TestLineWriter_Events(t *testing.T) {
+	/// Given
+	ch := make(chan LogEvent, 10)
+	w := newLineWriter(TailChannelWriter{event: LogEvent{Tag: "web", File: "/var/log/syslog"}, ch: ch})
+
+	/// When
+	_, err := w.Write([]byte("one\ntw"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("o\n"))
+	require.NoError(t, err)
+	close(ch)
+
+	/// Then
+	var out bytes.Buffer
+	for e := range ch {
+		assert.Equal(t, "/var/log/syslog", e.File)
+		assert.False(t, e.Received.IsZero())
+
+		b, err := TextFormatter{}.Format(e)
+		require.NoError(t, err)
+		out.Write(b)
+	}
+	assert.Equal(t, "web | one\nweb | two\n", out.String())
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"container/heap"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"time"
+)
+
+// orderer merges the events of all hosts in the order of the timestamps they were logged at. Every event is held back
+// for the window, so events logged before it that are still on their way can be passed on first. Events arriving after
+// a later one was already passed on are passed on right away, and marked as late.
+type orderer struct {
+	window     time.Duration
+	timestamps map[string]*specfile.TimestampSpec
+
+	pending eventHeap
+	arrived uint64
+	// last is the timestamp of each source's last line, used for the lines without a timestamp of their own.
+	last map[string]time.Time
+	// emitted is the timestamp of the last event passed on.
+	emitted time.Time
+}
+
+func newOrderer(window time.Duration, timestamps map[string]*specfile.TimestampSpec) *orderer {
+	return &orderer{window: window, timestamps: timestamps, last: map[string]time.Time{}}
+}
+
+// run orders the events from the channel until it's closed, then passes on the events still held back.
+func (o *orderer) run(in <-chan LogEvent, emit func(LogEvent)) {
+	timer := time.NewTimer(o.window)
+	defer timer.Stop()
+
+	for {
+		// Events are passed on in order, so only the window of the earliest one has to be waited for.
+		var due <-chan time.Time
+		if len(o.pending) > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(o.pending[0].Received.Add(o.window)))
+			due = timer.C
+		}
+
+		select {
+		case e, ok := <-in:
+			if !ok {
+				for len(o.pending) > 0 {
+					emit(o.pop())
+				}
+				return
+			}
+			o.add(e, emit)
+		case now := <-due:
+			o.release(now, emit)
+		}
+	}
+}
+
+// add holds back an event, or passes it on right away if it's late.
+func (o *orderer) add(e LogEvent, emit func(LogEvent)) {
+	source := e.Source()
+	ts, ok := time.Time{}, false
+	if spec, found := o.timestamps[e.Tag]; found {
+		ts, ok = spec.Parse(e.Line)
+	}
+	if !ok {
+		ts, ok = o.last[source]
+	}
+	if !ok {
+		ts = e.Received
+	}
+	o.last[source] = ts
+	e.Timestamp = ts
+
+	if ts.Before(o.emitted) {
+		e.Late = true
+		emit(e)
+		return
+	}
+
+	o.arrived++
+	heap.Push(&o.pending, pendingEvent{LogEvent: e, arrived: o.arrived})
+}
+
+// release passes on the earliest events, as long as they were held back for the window.
+func (o *orderer) release(now time.Time, emit func(LogEvent)) {
+	for len(o.pending) > 0 && !o.pending[0].Received.Add(o.window).After(now) {
+		emit(o.pop())
+	}
+}
+
+func (o *orderer) pop() LogEvent {
+	e := heap.Pop(&o.pending).(pendingEvent).LogEvent
+	o.emitted = e.Timestamp
+	return e
+}
+
+// pendingEvent is an event held back, numbered in the order it arrived to keep events with the same timestamp in
+// order.
+type pendingEvent struct {
+	LogEvent
+	arrived uint64
+}
+
+// eventHeap keeps the earliest pending event on top.
+type eventHeap []pendingEvent
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].Timestamp.Equal(h[j].Timestamp) {
+		return h[i].arrived < h[j].arrived
+	}
+	return h[i].Timestamp.Before(h[j].Timestamp)
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(pendingEvent)) }
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOrderer(t *testing.T) {
+	/// Given
+	ts := &specfile.TimestampSpec{Layout: "rfc3339"}
+	require.NoError(t, ts.Validate())
+	o := newOrderer(50*time.Millisecond, map[string]*specfile.TimestampSpec{"a": ts, "b": ts})
+
+	in := make(chan LogEvent)
+	out := make(chan LogEvent, 10)
+	go o.run(in, func(e LogEvent) { out <- e })
+
+	send := func(tag, line string) {
+		in <- LogEvent{Tag: tag, Line: []byte(line), Received: time.Now()}
+	}
+
+	/// When
+	send("a", "2023-01-01T10:00:02Z second")
+	send("b", "2023-01-01T10:00:01Z first")
+	send("b", "  continued")
+	send("a", "2023-01-01T10:00:03Z third")
+	first, continued, second, third := <-out, <-out, <-out, <-out
+
+	send("b", "2023-01-01T10:00:00Z late")
+	late := <-out
+	close(in)
+
+	/// Then
+	assert.Equal(t, "2023-01-01T10:00:01Z first", string(first.Line))
+	assert.Equal(t, "  continued", string(continued.Line), "lines without a timestamp should follow the line before them")
+	assert.Equal(t, "2023-01-01T10:00:02Z second", string(second.Line))
+	assert.Equal(t, "2023-01-01T10:00:03Z third", string(third.Line))
+	assert.False(t, third.Late)
+
+	assert.Equal(t, "2023-01-01T10:00:00Z late", string(late.Line))
+	assert.True(t, late.Late)
+	b, err := TextFormatter{}.Format(late)
+	require.NoError(t, err)
+	assert.Equal(t, "b | (late) 2023-01-01T10:00:00Z late\n", string(b))
+}
+
+func TestOrderer_FlushOnClose(t *testing.T) {
+	/// Given
+	o := newOrderer(time.Hour, map[string]*specfile.TimestampSpec{})
+	in := make(chan LogEvent, 2)
+	var out []string
+
+	/// When
+	in <- LogEvent{Tag: "a", Line: []byte("one"), Received: time.Now()}
+	in <- LogEvent{Tag: "a", Line: []byte("two"), Received: time.Now().Add(time.Millisecond)}
+	close(in)
+	o.run(in, func(e LogEvent) { out = append(out, string(e.Line)) })
+
+	/// Then
+	assert.Equal(t, []string{"one", "two"}, out)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// filePosition is where tailing a file left off, so it can be resumed after reconnecting.
+type filePosition struct {
+	inode  string
+	offset int64
+}
+
+// sessionPosition tracks the position of a single tail session. The remote command reports the inode and offset it
+// starts from on stderr, and every byte received on stdout moves the position forward.
+type sessionPosition struct {
+	mu       sync.Mutex
+	inode    string
+	start    int64
+	received int64
+	reported bool
+	// filtered is set if the output is filtered remotely, so the offsets are reported by grep instead of counted. Those
+	// keep counting from the start of the session when tail starts over, so the position is lost then.
+	filtered bool
+	lost     bool
+}
+
+// position returns where the session left off, or false if the remote command never reported its start.
+func (p *sessionPosition) position() (filePosition, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return filePosition{inode: p.inode, offset: p.start + p.received}, p.reported && !p.lost
+}
+
+func (p *sessionPosition) report(inode string, start int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inode = inode
+	p.start = start
+	p.reported = true
+}
+
+// restart is called when tail starts over from the beginning of a file. A truncated file is still the same file, but
+// after a rotation the inode of the file now being followed isn't known.
+func (p *sessionPosition) restart(rotated bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if rotated {
+		p.inode = ""
+	}
+	p.start = 0
+	p.received = 0
+	p.lost = p.filtered
+}
+
+func (p *sessionPosition) advance(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.received += int64(n)
+}
+
+// seen moves the position to the end of a line that passed the remote filter, given its end offset from where the
+// session started.
+func (p *sessionPosition) seen(end int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.received = end
+}
+
+// positionWriter counts the bytes written through it towards the session position.
+type positionWriter struct {
+	pos  *sessionPosition
+	next io.Writer
+}
+
+func (w positionWriter) Write(b []byte) (int, error) {
+	w.pos.advance(len(b))
+	return w.next.Write(b)
+}
+
+// tailRestarts are the messages GNU tail prints in the C locale when it starts over on the file it follows, after the
+// quoted file name.
+var tailRestarts = []struct {
+	suffix  string
+	rotated bool
+}{
+	{suffix: ": file truncated"},
+	{suffix: " has been replaced;  following new file", rotated: true},
+	{suffix: " has appeared;  following new file", rotated: true},
+}
+
+// stderrWriter reads the position reported by the remote command from the session's stderr, and passes anything else
+// on to the status output. The messages tail prints when it starts over on a truncated or rotated file reset the
+// position.
+type stderrWriter struct {
+	prefix string
+	// path is the file tailed, as given to the remote command, which tail's messages are matched against.
+	path   string
+	pos    *sessionPosition
+	status io.Writer
+	buf    bytes.Buffer
+}
+
+func (w *stderrWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(w.buf.Next(i + 1))
+		w.handleLine(strings.TrimRight(line, "\r\n"))
+	}
+
+	return len(b), nil
+}
+
+func (w *stderrWriter) handleLine(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 3 && fields[0] == positionMarker {
+		if start, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			inode := fields[1]
+			if inode == unknownInode {
+				inode = ""
+			}
+			w.pos.report(inode, start)
+			return
+		}
+	}
+
+	if message := strings.TrimPrefix(line, "tail: "); message != line {
+		for _, r := range tailRestarts {
+			if strings.HasSuffix(message, r.suffix) && w.isFile(strings.TrimSuffix(message, r.suffix)) {
+				w.pos.restart(r.rotated)
+				break
+			}
+		}
+	}
+
+	if line != "" {
+		_, _ = fmt.Fprintf(w.status, "%s: %s\n", w.prefix, line)
+	}
+}
+
+// isFile returns true if the file name quoted by tail is the file tailed. A leading ~/ was expanded by the remote
+// shell, so only the rest of the path has to match then.
+func (w *stderrWriter) isFile(quoted string) bool {
+	name, ok := unquoteTailName(quoted)
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(w.path, "~/") {
+		return strings.HasSuffix(name, w.path[1:])
+	}
+
+	return name == w.path
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSessionPosition(t *testing.T) {
+	/// Given
+	pos := &sessionPosition{}
+	_, ok := pos.position()
+	assert.False(t, ok, "there is no position before the remote command reported its start")
+
+	/// When
+	pos.report("1234", 100)
+	pos.advance(10)
+	pos.advance(5)
+
+	/// Then
+	p, ok := pos.position()
+	assert.True(t, ok)
+	assert.Equal(t, filePosition{inode: "1234", offset: 115}, p)
+}
+
+func TestStderrWriter_PositionMarker(t *testing.T) {
+	/// Given
+	pos := &sessionPosition{}
+	var status bytes.Buffer
+	w := &stderrWriter{prefix: "web", pos: pos, status: &status}
+
+	/// When
+	for _, chunk := range []string{"sshtail-pos", "ition 1234 5", "6\nsomething went wrong\r\n", "sshtail-position x y\n"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+
+	/// Then
+	p, ok := pos.position()
+	assert.True(t, ok, "the position should be reported, even if the marker is split across writes")
+	assert.Equal(t, filePosition{inode: "1234", offset: 56}, p)
+	assert.Equal(t, "web: something went wrong\nweb: sshtail-position x y\n", status.String(), "anything else should go to the status output")
+}
+
+func TestStderrWriter_RestartsPosition(t *testing.T) {
+	tests := map[string]struct {
+		path    string
+		message string
+		restart bool
+		inode   string
+	}{
+		"truncated": {path: "/var/log/app.log", message: "tail: /var/log/app.log: file truncated", restart: true, inode: "1234"},
+		"replaced": {path: "/var/log/app.log", message: "tail: '/var/log/app.log' has been replaced;  following new file",
+			restart: true},
+		"appeared": {path: "/var/log/app.log", message: "tail: '/var/log/app.log' has appeared;  following new file",
+			restart: true},
+		"quoted": {path: "/var/log/it's a log", message: `tail: "/var/log/it's a log": file truncated`, restart: true,
+			inode: "1234"},
+		"escaped": {path: "/var/log/new\nline", message: `tail: '/var/log/new'$'\n''line' has appeared;  following new file`,
+			restart: true},
+		"home":       {path: "~/app.log", message: "tail: /home/web/app.log: file truncated", restart: true, inode: "1234"},
+		"other file": {path: "/var/log/app.log", message: "tail: /var/log/other.log: file truncated"},
+		"name with the words": {path: "/var/log/file truncated",
+			message: "tail: '/var/log/file truncated' has become inaccessible: No such file or directory"},
+		"name with a message": {path: "/var/log/x' has appeared;  following new file",
+			message: `tail: cannot open "/var/log/x' has appeared;  following new file" for reading: Permission denied`},
+		"other message": {path: "/var/log/app.log", message: "tail: /var/log/app.log: file truncated or replaced, who knows"},
+		"not tail":      {path: "/var/log/app.log", message: "grep: /var/log/app.log: file truncated"},
+	}
+
+	for name, tc := range tests {
+		/// Given
+		pos := &sessionPosition{}
+		var status bytes.Buffer
+		w := &stderrWriter{prefix: "web", path: tc.path, pos: pos, status: &status}
+		_, err := w.Write([]byte("sshtail-position 1234 100\n"))
+		require.NoError(t, err)
+		pos.advance(50)
+
+		/// When
+		_, err = w.Write([]byte(tc.message + "\n"))
+		require.NoError(t, err)
+		pos.advance(7)
+
+		/// Then
+		p, ok := pos.position()
+		assert.True(t, ok, name)
+		if tc.restart {
+			assert.Equal(t, filePosition{inode: tc.inode, offset: 7}, p, name)
+		} else {
+			assert.Equal(t, filePosition{inode: "1234", offset: 157}, p, "%s should not restart the position", name)
+		}
+		assert.Equal(t, "web: "+tc.message+"\n", status.String(), name)
+	}
+}
+
+func TestTailCommand_RestartsPositionOfAnyName(t *testing.T) {
+	for _, name := range []string{"file truncated", "it's replaced: \"really\"", "has appeared;\tcaf\u00e9\n.log"} {
+		/// Given
+		dir := t.TempDir()
+		file := path.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte("first\nsecond\n"), 0644))
+		cmd := tailCommand{path: file, follow: specfile.FollowName, lines: 10}
+
+		/// When
+		run := startTailCommand(t, dir, cmd)
+		run.waitFor("first\nsecond\n")
+		require.NoError(t, os.WriteFile(file, []byte("new\n"), 0644))
+		run.waitFor("new\n")
+		_, truncated := run.stop()
+
+		run = startTailCommand(t, dir, cmd)
+		run.waitFor("new\n")
+		require.NoError(t, os.Rename(file, file+".1"))
+		require.NoError(t, os.WriteFile(file, []byte("newer\n"), 0644))
+		run.waitFor("newer\n")
+		_, replaced := run.stop()
+
+		/// Then
+		for _, stderr := range []string{truncated, replaced} {
+			pos := &sessionPosition{}
+			w := &stderrWriter{prefix: "web", path: file, pos: pos, status: io.Discard}
+			_, _ = positionWriter{pos: pos, next: io.Discard}.Write([]byte("first\nsecond\n"))
+			_, _ = w.Write([]byte(stderr))
+
+			p, ok := pos.position()
+			assert.True(t, ok, "file %q", name)
+			assert.Equal(t, int64(0), p.offset, "the position of file %q should start over, stderr: %q", name, stderr)
+		}
+	}
+}
+
+func TestTailCommand_Resume(t *testing.T) {
+	/// Given
+	dir := t.TempDir()
+	file := path.Join(dir, "it's a log")
+	require.NoError(t, os.WriteFile(file, []byte("first\nsecond\nthird\n"), 0644))
+
+	_, stderr := runTailCommand(t, dir, tailCommand{path: file, follow: specfile.FollowName, lines: 0}, "")
+	fields := strings.Fields(stderr)
+	require.Len(t, fields, 3, "expected the position marker, got %q", stderr)
+	inode := fields[1]
+
+	/// When
+	resumed, _ := runTailCommand(t, dir, tailCommand{path: file, resume: &filePosition{inode: inode, offset: 6}}, "third\n")
+	rotated, _ := runTailCommand(t, dir, tailCommand{path: file, resume: &filePosition{inode: "'; touch pwned; '", offset: 6}}, "third\n")
+
+	/// Then
+	assert.Equal(t, "second\nthird\n", resumed, "should resume from the offset")
+	assert.Equal(t, "first\nsecond\nthird\n", rotated, "a different inode should read the file from the start")
+	assert.NoFileExists(t, path.Join(dir, "pwned"))
+}
+
+func TestTailCommand_MissingFileFollowedByName(t *testing.T) {
+	/// Given
+	dir := t.TempDir()
+	file := path.Join(dir, "app.log")
+	cmd := tailCommand{path: file, follow: specfile.FollowName, lines: 10}
+
+	/// When
+	run := startTailCommand(t, dir, cmd)
+	run.waitFor("")
+	require.NoError(t, os.WriteFile(file, []byte("first\nsecond\n"), 0644))
+	// tail polls for a file that was missing, once a second by default.
+	run.waitFor("first\nsecond\n")
+	stdout, stderr := run.stop()
+
+	pos := &sessionPosition{}
+	w := &stderrWriter{prefix: "web", path: file, pos: pos, status: io.Discard}
+	_, _ = w.Write([]byte(stderr))
+	_, _ = positionWriter{pos: pos, next: io.Discard}.Write([]byte(stdout))
+
+	/// Then
+	assert.Contains(t, stderr, positionMarker+" - 0\n", "the position of a missing file should be unknown")
+	assert.Equal(t, "first\nsecond\n", stdout, "the file should be tailed once it appears")
+	p, ok := pos.position()
+	assert.True(t, ok)
+	assert.Equal(t, filePosition{inode: "", offset: 13}, p)
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import "strings"
+
+// shellQuote quotes a value for a POSIX shell, so it's always passed as a single word without any expansion. The value
+// is wrapped in single quotes, and every single quote in it ends the quoted part, adds an escaped quote, and starts a
+// new quoted part.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellQuotePath quotes a path like shellQuote, except for a leading ~/ which is left for the shell to expand to the home
+// directory, like it would for an unquoted path.
+func shellQuotePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return `"$HOME"/` + shellQuote(path[2:])
+	}
+
+	return shellQuote(path)
+}
+
+// tailEscapes are the characters GNU tail writes as backslash escapes in quoted file names.
+var tailEscapes = map[byte]byte{'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v'}
+
+// unquoteTailName reverses the quoting of a file name in the messages of GNU tail, which quotes names for the shell:
+// in single quotes, in double quotes if the name contains a single quote but nothing else to escape, and in $'...' for
+// control characters and bytes that aren't printable in the C locale. It returns false if s isn't quoted like that.
+func unquoteTailName(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return "", false
+			}
+			b.WriteString(s[i+1 : i+1+end])
+			i += end + 2
+		case s[i] == '"' || strings.HasPrefix(s[i:], "$'"):
+			closing := s[i]
+			if closing == '$' {
+				closing = '\''
+				i++
+			}
+			end, ok := unescapeTailName(&b, s, i+1, closing)
+			if !ok {
+				return "", false
+			}
+			i = end + 1
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteByte(s[i+1])
+			i += 2
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+
+	return b.String(), true
+}
+
+// unescapeTailName writes the escaped part of a quoted file name starting at i to b, and returns the index of the
+// closing quote.
+func unescapeTailName(b *strings.Builder, s string, i int, closing byte) (int, bool) {
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == closing:
+			return i, true
+		case c != '\\' || i+1 == len(s):
+			b.WriteByte(c)
+			i++
+		case s[i+1] >= '0' && s[i+1] <= '7':
+			n, j := 0, i+1
+			for ; j < len(s) && j < i+4 && s[j] >= '0' && s[j] <= '7'; j++ {
+				n = n*8 + int(s[j]-'0')
+			}
+			b.WriteByte(byte(n))
+			i = j
+		default:
+			if e, ok := tailEscapes[s[i+1]]; ok {
+				b.WriteByte(e)
+			} else {
+				b.WriteByte(s[i+1])
+			}
+			i += 2
+		}
+	}
+
+	return 0, false
+}
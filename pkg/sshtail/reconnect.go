@@ -0,0 +1,393 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"golang.org/x/crypto/ssh"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// stablePeriod is how long a connection, or the tail of a file, has to last before its backoff starts over. A host or
+// file that drops again sooner continues where its backoff left off, so it still runs out of retries.
+var stablePeriod = time.Minute
+
+// errFilesStopped is returned by waitDisconnect when the tails of all files exited and none of them is restarted.
+var errFilesStopped = errors.New("no files left to tail")
+
+// retryState is the backoff of reconnecting a host, or of restarting the tail of one of its files.
+type retryState struct {
+	backoff time.Duration
+	attempt int
+}
+
+func (c *TailSshClient) newRetryState() *retryState {
+	backoff := c.host.Reconnect.InitialBackoff
+	if backoff <= 0 {
+		backoff = specfile.DefaultInitialBackoff
+	}
+
+	return &retryState{backoff: backoff}
+}
+
+// next counts an attempt and returns how long to wait before making it, doubling the wait for the next one.
+func (r *retryState) next(maxBackoff time.Duration) time.Duration {
+	wait := jitter(r.backoff)
+	r.attempt++
+	r.backoff = nextBackoff(r.backoff, maxBackoff)
+	return wait
+}
+
+// exhausted returns true if no attempts are left. There's no limit if retries is zero.
+func (r *retryState) exhausted(retries int) bool {
+	return retries > 0 && r.attempt >= retries
+}
+
+// supervise watches the connection of a started client and reconnects it with exponential backoff when it drops.
+// It returns once the client is closed, when the host can't be reconnected anymore, or when no file is tailed anymore.
+func (c *TailSshClient) supervise(ch chan<- LogEvent) {
+	status := c.status
+	retry := c.newRetryState()
+	for {
+		connected := time.Now()
+		err := c.waitDisconnect(ch)
+		if c.isClosed() {
+			return
+		}
+
+		if errors.Is(err, errFilesStopped) {
+			_, _ = fmt.Fprintf(status, "%s: %v, disconnecting\n", c.tag, err)
+			return
+		}
+
+		reconnect := c.host.Reconnect
+		if !reconnect.Enabled() {
+			_, _ = fmt.Fprintf(status, "%s: disconnected (%v)\n", c.tag, err)
+			return
+		}
+
+		if time.Since(connected) >= stablePeriod {
+			retry = c.newRetryState()
+		}
+
+		_, _ = fmt.Fprintf(status, "%s: disconnected (%v), reconnecting\n", c.tag, err)
+		if !c.reconnect(ch, retry, err) {
+			return
+		}
+
+		_, _ = fmt.Fprintf(status, "%s: reconnected\n", c.tag)
+	}
+}
+
+// sessionEnd is a tail session that ended, with the error it ended with.
+type sessionEnd struct {
+	ts  *tailSession
+	err error
+}
+
+// waitDisconnect blocks until the connection is lost, then tears down what is left of the connection and remembers
+// where each file left off. Keepalives are sent while waiting, so a dead connection is detected even if TCP doesn't
+// notice. The tail of a single file exiting doesn't affect the connection, it's restarted on its own.
+func (c *TailSshClient) waitDisconnect(ch chan<- LogEvent) error {
+	c.mu.Lock()
+	client := c.client
+	sessions := c.sessions
+	c.mu.Unlock()
+
+	lost := make(chan error, 2)
+	go func() {
+		err := client.Wait()
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		lost <- err
+	}()
+	go func() {
+		lost <- keepalive(client, c.keepaliveInterval())
+	}()
+
+	stop := make(chan struct{})
+	ended := make(chan sessionEnd)
+	var running sync.WaitGroup
+	watch := func(ts *tailSession) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			err := ts.session.Wait()
+			select {
+			case ended <- sessionEnd{ts, err}:
+			case <-stop:
+			}
+		}()
+	}
+	for _, ts := range sessions {
+		watch(ts)
+	}
+
+	restarts := make(chan *specfile.FileSpec)
+	var timers []*time.Timer
+	pending := 0
+
+	var err error
+	for err == nil {
+		select {
+		case err = <-lost:
+		case end := <-ended:
+			if !commandExited(end.err) {
+				// The session was cut off without an exit status, which means the connection is going away.
+				err = fmt.Errorf("file %s: %w", end.ts.file.Path, end.err)
+				break
+			}
+
+			if wait, restart := c.sessionExited(end); restart {
+				pending++
+				file := end.ts.file
+				timers = append(timers, time.AfterFunc(wait, func() {
+					select {
+					case restarts <- file:
+					case <-stop:
+					}
+				}))
+			}
+		case file := <-restarts:
+			pending--
+			ts, startErr := c.restartSession(file, ch)
+			if startErr != nil {
+				err = fmt.Errorf("file %s: %w", file.Path, startErr)
+				break
+			}
+			watch(ts)
+		}
+
+		if err == nil && pending == 0 && !c.Started() {
+			err = errFilesStopped
+		}
+	}
+
+	close(stop)
+	for _, timer := range timers {
+		timer.Stop()
+	}
+
+	c.mu.Lock()
+	for _, ts := range c.sessions {
+		_ = ts.session.Close()
+	}
+	_ = c.client.Close()
+	c.mu.Unlock()
+
+	// Only once the sessions ended, all of their output has been counted towards their positions, and what's left of a
+	// partial line or record can be passed on.
+	running.Wait()
+
+	c.mu.Lock()
+	for _, ts := range c.sessions {
+		ts.flush()
+	}
+	c.savePositions()
+	c.sessions = nil
+	c.mu.Unlock()
+
+	return err
+}
+
+// commandExited returns true if a session ended because the remote command exited, rather than the connection
+// dropping.
+func commandExited(err error) bool {
+	var exitErr *ssh.ExitError
+	return err == nil || errors.As(err, &exitErr)
+}
+
+// sessionExited handles the tail of a single file exiting while the connection is still up. The file's position is
+// remembered, and it's restarted with its own backoff, unless it ran out of retries. It returns how long to wait
+// before restarting the file, or false if it's not restarted.
+func (c *TailSshClient) sessionExited(end sessionEnd) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ts := end.ts
+	for i, s := range c.sessions {
+		if s == ts {
+			c.sessions = append(c.sessions[:i:i], c.sessions[i+1:]...)
+			break
+		}
+	}
+	ts.flush()
+	if p, ok := ts.pos.position(); ok {
+		c.positions[ts.file.Tag] = p
+	}
+
+	reason := "exited"
+	if end.err != nil {
+		reason = fmt.Sprintf("exited (%v)", end.err)
+	}
+
+	reconnect := c.host.Reconnect
+	if !reconnect.Enabled() {
+		_, _ = fmt.Fprintf(c.status, "%s: tail of %s %s\n", ts.prefix, ts.file.Path, reason)
+		c.stopFile(ts.file)
+		return 0, false
+	}
+
+	if c.fileRetries == nil {
+		c.fileRetries = map[string]*retryState{}
+	}
+	retry := c.fileRetries[ts.file.Tag]
+	if retry == nil || time.Since(ts.started) >= stablePeriod {
+		retry = c.newRetryState()
+		c.fileRetries[ts.file.Tag] = retry
+	}
+
+	if retry.exhausted(reconnect.Retries()) {
+		_, _ = fmt.Fprintf(c.status, "%s: tail of %s %s, giving up after %d restarts\n", ts.prefix, ts.file.Path, reason, retry.attempt)
+		c.stopFile(ts.file)
+		return 0, false
+	}
+
+	_, _ = fmt.Fprintf(c.status, "%s: tail of %s %s, restarting\n", ts.prefix, ts.file.Path, reason)
+	return retry.next(c.maxBackoff()), true
+}
+
+// stopFile makes sure the file isn't tailed anymore, not even after reconnecting. The caller must hold the lock.
+func (c *TailSshClient) stopFile(file *specfile.FileSpec) {
+	if c.stopped == nil {
+		c.stopped = map[string]bool{}
+	}
+	c.stopped[file.Tag] = true
+}
+
+// restartSession starts tailing a single file again on the current connection, resuming where it left off.
+func (c *TailSshClient) restartSession(file *specfile.FileSpec, ch chan<- LogEvent) (*tailSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errors.New("client closed")
+	}
+
+	ts, err := c.startFile(file, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sessions = append(c.sessions, ts)
+	return ts, nil
+}
+
+// reconnect tries to connect to the host again and restart its sessions, waiting longer after every attempt. The
+// backoff carries over from earlier reconnects, unless the connection was stable in between. It returns false if the
+// client was closed in the meantime, or if the host ran out of retries.
+func (c *TailSshClient) reconnect(ch chan<- LogEvent, retry *retryState, cause error) bool {
+	status := c.status
+	retries := c.host.Reconnect.Retries()
+
+	for {
+		if retry.exhausted(retries) {
+			_, _ = fmt.Fprintf(status, "%s: giving up after %d attempts (%v)\n", c.tag, retry.attempt, cause)
+			return false
+		}
+
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(retry.next(c.maxBackoff())):
+		}
+
+		err := c.redial(ch)
+		if err == nil {
+			return true
+		}
+
+		if c.isClosed() {
+			return false
+		}
+
+		cause = err
+		if !retry.exhausted(retries) {
+			_, _ = fmt.Fprintf(status, "%s: reconnect attempt %d failed (%v)\n", c.tag, retry.attempt, err)
+		}
+	}
+}
+
+func (c *TailSshClient) maxBackoff() time.Duration {
+	if c.host.Reconnect.MaxBackoff <= 0 {
+		return specfile.DefaultMaxBackoff
+	}
+
+	return c.host.Reconnect.MaxBackoff
+}
+
+// nextBackoff doubles the time waited after a failed attempt, up to the maximum.
+func nextBackoff(backoff time.Duration, maxBackoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+
+	return backoff
+}
+
+// redial replaces the lost connection with a new one and starts the sessions on it.
+func (c *TailSshClient) redial(ch chan<- LogEvent) error {
+	client, err := c.jumps.dial(c.tag, c.host)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		_ = client.Close()
+		return errors.New("client closed")
+	}
+
+	c.client = client
+	if err = c.startSessions(ch); err != nil {
+		_ = client.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *TailSshClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+func (c *TailSshClient) keepaliveInterval() time.Duration {
+	if c.host.KeepaliveInterval <= 0 {
+		return specfile.DefaultKeepaliveInterval
+	}
+
+	return c.host.KeepaliveInterval
+}
+
+// jitter randomizes a delay to somewhere between half of it and all of it, so hosts that dropped together don't all
+// reconnect at the same instant.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"errors"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusRecorder collects status messages written from several goroutines.
+type statusRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.buf.Write(b)
+}
+
+func (r *statusRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.buf.String()
+}
+
+func TestJitter(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, time.Millisecond, time.Second, time.Minute} {
+		for i := 0; i < 100; i++ {
+			j := jitter(d)
+			assert.GreaterOrEqual(t, int64(j), int64(d/2), "jitter of %v", d)
+			assert.LessOrEqual(t, int64(j), int64(d), "jitter of %v", d)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(time.Second, time.Minute))
+	assert.Equal(t, 40*time.Second, nextBackoff(20*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextBackoff(40*time.Second, time.Minute), "the backoff should be capped")
+	assert.Equal(t, time.Minute, nextBackoff(time.Minute, time.Minute))
+}
+
+func TestReconnect_MaxRetries(t *testing.T) {
+	/// Given
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close(), "nothing should be listening on the port")
+
+	status := &statusRecorder{}
+	maxRetries := 3
+	client := &TailSshClient{
+		tag:    "web",
+		status: status,
+		done:   make(chan struct{}),
+		jumps:  newJumpPool(status),
+		host: &specfile.HostSpec{Hostname: "127.0.0.1", Port: port, Username: "test", Auth: specfile.AuthFile,
+			IdentityFile: writeKeyFile(t, generateKey(t)), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+			Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}},
+	}
+
+	/// When
+	reconnected := client.reconnect(make(chan LogEvent), client.newRetryState(), errors.New("connection lost"))
+
+	/// Then
+	assert.False(t, reconnected)
+	assert.Equal(t, 2, strings.Count(status.String(), "reconnect attempt"), "status: %s", status)
+	assert.Contains(t, status.String(), "web: giving up after 3 attempts")
+}
+
+func TestReconnect_StopsWhenClosed(t *testing.T) {
+	/// Given
+	client := &TailSshClient{
+		tag:    "web",
+		status: io.Discard,
+		done:   make(chan struct{}),
+		host:   &specfile.HostSpec{Reconnect: specfile.ReconnectSpec{InitialBackoff: time.Hour}},
+	}
+	close(client.done)
+
+	/// When
+	reconnected := client.reconnect(make(chan LogEvent), client.newRetryState(), errors.New("connection lost"))
+
+	/// Then
+	assert.False(t, reconnected, "a closed client should not be reconnected")
+}
+
+func TestReconnect_CarriesOverAttempts(t *testing.T) {
+	/// Given
+	status := &statusRecorder{}
+	maxRetries := 3
+	client := &TailSshClient{
+		tag:    "web",
+		status: status,
+		done:   make(chan struct{}),
+		host:   &specfile.HostSpec{Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries, InitialBackoff: time.Hour}},
+	}
+	retry := client.newRetryState()
+	for i := 0; i < maxRetries; i++ {
+		retry.next(time.Hour)
+	}
+
+	/// When
+	reconnected := client.reconnect(make(chan LogEvent), retry, errors.New("connection lost"))
+
+	/// Then
+	assert.False(t, reconnected, "attempts made before the connection dropped again should count")
+	assert.Contains(t, status.String(), "web: giving up after 3 attempts (connection lost)")
+}
+
+// supervised starts tailing the host and supervises the client in the background. The returned channel is closed once
+// the client isn't supervised anymore.
+func supervised(t *testing.T, host *specfile.HostSpec, ch chan LogEvent) (*TailSshClient, *statusRecorder, <-chan struct{}) {
+	require.NoError(t, host.Validate())
+
+	client, err := NewTailSshClient("web", host)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	status := &statusRecorder{}
+	client.status = status
+
+	require.NoError(t, client.StartSession(ch))
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		client.supervise(ch)
+	}()
+
+	return client, status, stopped
+}
+
+// waitStopped fails the test if the client is still supervised after a few seconds.
+func waitStopped(t *testing.T, stopped <-chan struct{}, status *statusRecorder) {
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the client should have stopped", "status: %s", status)
+	}
+}
+
+func TestTailSshClient_MissingFileDoesNotReconnect(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	maxRetries := 3
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: path.Join(t.TempDir(), "missing.log"),
+		Follow:    specfile.FollowDescriptor,
+		Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+
+	/// When
+	_, status, stopped := supervised(t, host, make(chan LogEvent, 16))
+
+	/// Then
+	waitStopped(t, stopped, status)
+	assert.Equal(t, 1, server.connections(), "a file that can't be tailed should not drop the connection, status: %s", status)
+	assert.Equal(t, 3, strings.Count(status.String(), "restarting"), "status: %s", status)
+	assert.Contains(t, status.String(), "giving up after 3 restarts")
+	assert.Contains(t, status.String(), "web: no files left to tail")
+}
+
+func TestTailSshClient_MissingFileFollowedByName(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	file := path.Join(t.TempDir(), "app.log")
+	maxRetries := 0
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: file, Follow: specfile.FollowName,
+		Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries}}
+
+	ch := make(chan LogEvent, 16)
+	_, status, _ := supervised(t, host, ch)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(status.String(), "No such file or directory")
+	}, 5*time.Second, 10*time.Millisecond, "status: %s", status)
+
+	/// When
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0644))
+
+	/// Then
+	assert.Equal(t, "first", nextLine(t, ch), "the file should be tailed once it appears, status: %s", status)
+	assert.NotContains(t, status.String(), "restarting")
+	assert.Equal(t, 1, server.connections(), "status: %s", status)
+}
+
+func TestTailSshClient_RestartsOnlyExitedFile(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	file := path.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0644))
+
+	maxRetries := 2
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, Follow: specfile.FollowDescriptor,
+		Files:     []*specfile.FileSpec{{Path: file}, {Path: path.Join(t.TempDir(), "missing.log")}},
+		Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+
+	ch := make(chan LogEvent, 16)
+	_, status, _ := supervised(t, host, ch)
+	assert.Equal(t, "first", nextLine(t, ch))
+
+	/// When
+	assert.Eventually(t, func() bool {
+		return strings.Contains(status.String(), "giving up after 2 restarts")
+	}, 5*time.Second, 10*time.Millisecond, "status: %s", status)
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("second\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	/// Then
+	assert.Equal(t, "second", nextLine(t, ch), "the other file should keep being tailed, status: %s", status)
+	assert.Equal(t, 1, server.connections(), "status: %s", status)
+	assert.NotContains(t, status.String(), "disconnected")
+}
+
+func TestTailSshClient_FlakyConnectionRunsOutOfRetries(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	file := path.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0644))
+
+	maxRetries := 3
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: writeKeyFile(t, key), KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: file,
+		Reconnect: specfile.ReconnectSpec{MaxRetries: &maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+	server.dropConnectionsAfter(200 * time.Millisecond)
+
+	/// When
+	_, status, stopped := supervised(t, host, make(chan LogEvent, 16))
+
+	/// Then
+	waitStopped(t, stopped, status)
+	assert.Contains(t, status.String(), "web: giving up after 3 attempts")
+	assert.Equal(t, 4, server.connections(), "reconnects that drop right away should count towards the retries, status: %s", status)
+}
+
+// nextLine returns the next line tailed, failing the test if there's none within a few seconds.
+func nextLine(t *testing.T, ch <-chan LogEvent) string {
+	select {
+	case e := <-ch:
+		return string(e.Line)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for a line")
+		return ""
+	}
+}
+
+func TestTailSshClient_ReconnectsAndResumes(t *testing.T) {
+	/// Given
+	key := generateKey(t)
+	server := newTestServer(t, generateKey(t), key)
+	identityFile := writeKeyFile(t, key)
+
+	file := path.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(file, []byte("first\nsecond\n"), 0644))
+
+	host := &specfile.HostSpec{Hostname: "127.0.0.1", Port: server.port(), Username: "test", Auth: specfile.AuthFile,
+		IdentityFile: identityFile, KnownHostsFile: path.Join(t.TempDir(), "known_hosts"),
+		HostKeyPolicy: specfile.HostKeyPolicyAcceptNew, File: file,
+		Reconnect: specfile.ReconnectSpec{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}}
+	require.NoError(t, host.Validate())
+
+	client, err := NewTailSshClient("web", host)
+	require.NoError(t, err)
+	defer client.Close()
+	status := &statusRecorder{}
+	client.status = status
+
+	ch := make(chan LogEvent, 16)
+	require.NoError(t, client.StartSession(ch))
+	go client.supervise(ch)
+
+	assert.Equal(t, "first", nextLine(t, ch))
+	assert.Equal(t, "second", nextLine(t, ch))
+
+	/// When
+	// The key was loaded when connecting, so reconnecting shouldn't need the file anymore.
+	require.NoError(t, os.Remove(identityFile))
+	server.dropConnections()
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("third\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	/// Then
+	assert.Equal(t, "third", nextLine(t, ch), "tailing should resume where it left off, status: %s", status)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(status.String(), "web: reconnected")
+	}, 5*time.Second, 10*time.Millisecond, "status: %s", status)
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"io"
+	"sync"
+	"time"
+)
+
+// recordWriter groups the lines written to it into records, and writes each record on as a whole. A record is passed
+// on once the next one starts, it reaches the maximum number of lines, or no line was added to it for the flush
+// timeout. Every write is expected to be a single line.
+type recordWriter struct {
+	multiline *specfile.MultilineSpec
+	next      io.Writer
+
+	mu     sync.Mutex
+	buf    []byte
+	lines  int
+	timer  *time.Timer
+	closed bool
+}
+
+func newRecordWriter(multiline *specfile.MultilineSpec, next io.Writer) *recordWriter {
+	return &recordWriter{multiline: multiline, next: next}
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	// The line is matched without its line break, so patterns can be anchored at its end.
+	if w.lines > 0 && w.multiline.StartsRecord(bytes.TrimSuffix(b, []byte{'\n'})) {
+		if err := w.flush(); err != nil {
+			return len(b), err
+		}
+	}
+
+	w.buf = append(w.buf, b...)
+	w.lines++
+	if w.lines >= w.multiline.MaxLines {
+		return len(b), w.flush()
+	}
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.multiline.FlushTimeout, w.timedOut)
+	} else {
+		w.timer.Reset(w.multiline.FlushTimeout)
+	}
+	return len(b), nil
+}
+
+func (w *recordWriter) timedOut() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_ = w.flush()
+}
+
+// flush passes on the record, if there is one. The caller must hold the lock.
+func (w *recordWriter) flush() error {
+	if w.lines == 0 {
+		return nil
+	}
+
+	record := w.buf
+	w.buf = nil
+	w.lines = 0
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	_, err := w.next.Write(record)
+	return err
+}
+
+// Close passes on the record, if there is one. Nothing can be written afterwards.
+func (w *recordWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.flush()
+}
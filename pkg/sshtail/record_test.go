@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func writeLines(t *testing.T, w io.Writer, lines ...string) {
+	for _, line := range lines {
+		_, err := w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+}
+
+func TestRecordWriter_Start(t *testing.T) {
+	/// Given
+	multiline := &specfile.MultilineSpec{Start: `^\d{4}-`, FlushTimeout: time.Hour}
+	require.NoError(t, multiline.Validate())
+	rec := &lineRecorder{}
+	w := newRecordWriter(multiline, rec)
+
+	/// When
+	writeLines(t, w,
+		"2023-01-01 ERROR failed\n",
+		"java.lang.IllegalStateException: broken\n",
+		"\tat Main.main(Main.java:3)\n",
+		"2023-01-01 INFO recovered\n",
+	)
+
+	/// Then
+	assert.Equal(t, []string{"2023-01-01 ERROR failed\njava.lang.IllegalStateException: broken\n\tat Main.main(Main.java:3)\n"}, rec.get())
+
+	require.NoError(t, w.Close())
+	assert.Equal(t, "2023-01-01 INFO recovered\n", rec.get()[1])
+}
+
+func TestRecordWriter_Continuation(t *testing.T) {
+	/// Given
+	multiline := &specfile.MultilineSpec{Continuation: `^\s`, MaxLines: 3, FlushTimeout: 10 * time.Millisecond}
+	require.NoError(t, multiline.Validate())
+	rec := &lineRecorder{}
+	w := newRecordWriter(multiline, rec)
+
+	/// When
+	writeLines(t, w, "Traceback:\n", "  a\n", "  b\n", "  c\n", "Error\n")
+
+	/// Then
+	assert.Equal(t, []string{"Traceback:\n  a\n  b\n", "  c\n"}, rec.get(), "records should be cut at the max lines")
+
+	deadline := time.Now().Add(time.Second)
+	for len(rec.get()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"Traceback:\n  a\n  b\n", "  c\n", "Error\n"}, rec.get(), "the last record should be flushed after the timeout")
+}
+
+func TestRecordWriter_PatternAnchoredAtLineEnd(t *testing.T) {
+	/// Given
+	multiline := &specfile.MultilineSpec{Continuation: `^\s+at .*$|^\s*$`, FlushTimeout: time.Hour}
+	require.NoError(t, multiline.Validate())
+	rec := &lineRecorder{}
+	w := newRecordWriter(multiline, rec)
+
+	/// When
+	writeLines(t, w,
+		"java.lang.IllegalStateException: broken\n",
+		"\tat Main.run(Main.java:7)\n",
+		"\tat Main.main(Main.java:3)\n",
+		"\n",
+		"recovered\n",
+	)
+
+	/// Then
+	assert.Equal(t, []string{"java.lang.IllegalStateException: broken\n\tat Main.run(Main.java:7)\n\tat Main.main(Main.java:3)\n\n"}, rec.get(),
+		"a continuation pattern anchored at the end of the line should match")
+}
+
+func TestRecordWriter_Events(t *testing.T) {
+	/// Given
+	multiline := &specfile.MultilineSpec{Continuation: `^\s`}
+	require.NoError(t, multiline.Validate())
+	ch := make(chan LogEvent, 10)
+	w := newLineWriter(newRecordWriter(multiline, TailChannelWriter{event: LogEvent{Tag: "app"}, ch: ch}))
+
+	/// When
+	_, err := w.Write([]byte("panic: oops\n\tgoroutine 1\nnext\n"))
+	require.NoError(t, err)
+
+	/// Then
+	e := <-ch
+	b, err := TextFormatter{}.Format(e)
+	require.NoError(t, err)
+	assert.Equal(t, "app | panic: oops\n\tgoroutine 1\n", string(b))
+}
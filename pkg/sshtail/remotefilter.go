@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// grepProbe checks that the remote grep supports the options used for remote filtering, which aren't all POSIX.
+const grepProbe = `sh -c 'printf "x\n" | grep --line-buffered -b -E -e x'`
+
+// grepProbeOutput is what grepProbe prints if the options are supported.
+const grepProbeOutput = "0:x\n"
+
+// grepCommand returns the grep command passing a superset of the lines that pass the filter, so it can be run
+// remotely to cut down on what is sent over the connection. The lines are still filtered locally afterwards, as grep
+// can only check the includes, or the excludes, in a single pass. Every line is prefixed with its byte offset, so the
+// position in the file can be tracked. False is returned if the filter can't be narrowed down by grep at all, or if one
+// of its patterns isn't portable.
+func grepCommand(filter *specfile.FilterSpec) (string, bool) {
+	patterns, invert := filter.Include, filter.Invert
+	if len(patterns) == 0 {
+		patterns, invert = filter.Exclude, !invert
+	} else if len(filter.Exclude) > 0 && invert {
+		// The lines to pass either don't match an include, or match an exclude.
+		return "", false
+	}
+	if len(patterns) == 0 || unportablePattern(patterns) != "" {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString("grep --line-buffered -b")
+	if filter.IgnoreCase {
+		b.WriteString(" -i")
+	}
+	if invert {
+		b.WriteString(" -v")
+	}
+	b.WriteString(" -E")
+	for _, p := range patterns {
+		fmt.Fprintf(&b, " -e %s", shellQuote(p))
+	}
+
+	return b.String(), true
+}
+
+// unportablePattern returns the first pattern that isn't portable, or an empty string if they all are.
+func unportablePattern(patterns []string) string {
+	for _, p := range patterns {
+		if !portableERE(p) {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// portableERE returns true if the pattern means the same to grep -E, as a POSIX extended regular expression, as it does
+// in Go. Since grep may drop lines on the host, it has to match exactly the same lines. Only punctuation may be escaped,
+// and not within bracket expressions, where a backslash is a literal in POSIX. Groups starting with (?, like flags and
+// non-capturing groups, and lazy quantifiers aren't POSIX either.
+func portableERE(pattern string) bool {
+	inBracket := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		next := byte(0)
+		if i+1 < len(pattern) {
+			next = pattern[i+1]
+		}
+
+		switch {
+		case inBracket:
+			switch {
+			case c == '\\':
+				return false
+			case c == '[' && (next == ':' || next == '.' || next == '='):
+				// Character classes like [:digit:] end with the same character they start with, followed by ].
+				end := strings.Index(pattern[i+2:], string(next)+"]")
+				if end < 0 {
+					return false
+				}
+				i += end + 3
+			case c == ']':
+				inBracket = false
+			}
+		case c == '\\':
+			if next == 0 || isAlphanumeric(next) {
+				return false
+			}
+			i++
+		case c == '[':
+			inBracket = true
+			// A ] right after the opening bracket, or after its negation, is part of the set.
+			if next == '^' {
+				i++
+				next = 0
+				if i+1 < len(pattern) {
+					next = pattern[i+1]
+				}
+			}
+			if next == ']' {
+				i++
+			}
+		case c == '(' && next == '?':
+			return false
+		case (c == '*' || c == '+' || c == '?' || c == '}') && next == '?':
+			return false
+		}
+	}
+
+	return !inBracket
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// offsetWriter strips the byte offsets grep prefixes each line with, and moves the session position to the end of
+// each line.
+type offsetWriter struct {
+	pos  *sessionPosition
+	next io.Writer
+
+	inLine bool
+	prefix []byte
+	offset int64
+	length int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if !w.inLine {
+			i := bytes.IndexByte(b, ':')
+			if i < 0 {
+				w.prefix = append(w.prefix, b...)
+				break
+			}
+
+			w.prefix = append(w.prefix, b[:i]...)
+			offset, err := strconv.ParseInt(string(w.prefix), 10, 64)
+			if err != nil {
+				return n, fmt.Errorf("invalid offset from grep: %q", w.prefix)
+			}
+			w.offset, w.length, w.inLine, w.prefix = offset, 0, true, w.prefix[:0]
+			b = b[i+1:]
+			continue
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		chunk := b
+		if i >= 0 {
+			chunk = b[:i+1]
+		}
+		if _, err := w.next.Write(chunk); err != nil {
+			return n, err
+		}
+		w.length += int64(len(chunk))
+		b = b[len(chunk):]
+
+		if i >= 0 {
+			w.pos.seen(w.offset + w.length)
+			w.inLine = false
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"errors"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testServer is an in-process SSH server that only accepts the authorized key.
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+
+	mu    sync.Mutex
+	conns []net.Conn
+	// dropAfter closes every connection this long after it was accepted, if set.
+	dropAfter time.Duration
+	// hangTunnels leaves tunnels unanswered, like a jump host that can't reach the target, if set.
+	hangTunnels bool
+}
+
+func newTestServer(t *testing.T, hostKey ed25519.PrivateKey, authorized ed25519.PrivateKey) *testServer {
+	authorizedKey, err := ssh.NewPublicKey(authorized.Public())
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{listener: listener, config: config, hostKey: hostSigner.PublicKey()}
+	t.Cleanup(s.close)
+	go s.serve()
+	return s
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	config := *s.config
+	dropAfter := s.dropAfter
+	hangTunnels := s.hangTunnels
+	s.mu.Unlock()
+
+	if dropAfter > 0 {
+		timer := time.AfterFunc(dropAfter, func() {
+			_ = conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		for ch := range chans {
+			switch ch.ChannelType() {
+			case "direct-tcpip":
+				if !hangTunnels {
+					go forward(ch)
+				}
+			case "session":
+				go execSession(ch)
+			default:
+				_ = ch.Reject(ssh.Prohibited, "unsupported channel")
+			}
+		}
+	}()
+	_ = serverConn.Wait()
+}
+
+// execSession runs the command of a session with the local shell, like the login shell of a remote user would. The
+// command runs in its own process group, which is killed once the session is closed or the connection is lost.
+func execSession(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	var cmd *exec.Cmd
+	for req := range reqs {
+		if req.Type != "exec" || cmd != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		cmd = exec.Command("sh", "-c", payload.Command)
+		cmd.Stdout = ch
+		cmd.Stderr = ch.Stderr()
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+
+		go func(cmd *exec.Cmd) {
+			_ = cmd.Wait()
+			status := struct{ Status uint32 }{uint32(cmd.ProcessState.ExitCode())}
+			_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(&status))
+			_ = ch.Close()
+		}(cmd)
+	}
+
+	if cmd != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// forward tunnels a direct-tcpip channel to the address it asks for, like a jump host does.
+func forward(newCh ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &target); err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		_, _ = io.Copy(ch, conn)
+		_ = ch.Close()
+	}()
+	_, _ = io.Copy(conn, ch)
+	_ = conn.Close()
+}
+
+// addHostKey lets the server present another host key, of a different type than the ones it already has.
+func (s *testServer) addHostKey(t *testing.T, key interface{}) ssh.PublicKey {
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.AddHostKey(signer)
+	return signer.PublicKey()
+}
+
+// dropConnectionsAfter makes the server close every new connection after the given time, like a flaky network.
+func (s *testServer) dropConnectionsAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropAfter = d
+}
+
+// hangNewTunnels makes the server leave the tunnels of new connections unanswered.
+func (s *testServer) hangNewTunnels() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hangTunnels = true
+}
+
+// connections returns how many connections the server accepted since they were last dropped.
+func (s *testServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *testServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// endpoint returns an endpoint for the server that authenticates with the key in the identity file.
+func (s *testServer) endpoint(identityFile string, knownHostsFile string, policy string) endpoint {
+	return endpoint{
+		tag:            "web",
+		hostname:       "127.0.0.1",
+		port:           s.port(),
+		username:       "test",
+		identityFile:   identityFile,
+		auth:           specfile.AuthFile,
+		knownHostsFile: knownHostsFile,
+		hostKeyPolicy:  policy,
+		status:         io.Discard,
+	}
+}
+
+// dropConnections closes all connections accepted so far, as if the network went down.
+func (s *testServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
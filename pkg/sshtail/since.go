@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"io"
+	"time"
+)
+
+// sinceWriter drops the lines of the backlog that are older than a point in time. Once the first line at or after
+// that time comes by, it and everything after it is passed on untouched. Lines without a timestamp are dropped along
+// with the older lines, since they belong to the record before them. Every write is expected to be a single line, or a
+// record of lines.
+//
+// Only the last lines of a file are searched, so if the first line with a timestamp is already newer than the point in
+// time, a warning is written to the status output, since older lines may have been cut off. A warning is written as
+// well once as many lines as the backlog holds went by without a timestamp, which usually means the timestamp format
+// doesn't match the file.
+type sinceWriter struct {
+	since     time.Time
+	timestamp *specfile.TimestampSpec
+	next      io.Writer
+	passing   bool
+
+	prefix string
+	lines  int
+	status io.Writer
+	seen   bool
+	// dropped counts the lines dropped before the first timestamp was seen.
+	dropped int
+}
+
+func (w *sinceWriter) Write(b []byte) (int, error) {
+	if !w.passing {
+		// Timestamps are found in the line like the orderer does, without its line break.
+		ts, ok := w.timestamp.Parse(bytes.TrimSuffix(b, []byte{'\n'}))
+		if ok && !w.seen {
+			w.seen = true
+			if ts.After(w.since) {
+				_, _ = fmt.Fprintf(w.status, "%s: the last %d lines only go back to %s, lines since %s may be missing, use --lines to search further back\n",
+					w.prefix, w.lines, ts.Format(time.RFC3339), w.since.Format(time.RFC3339))
+			}
+		}
+		if !ok && !w.seen && w.dropped < w.lines {
+			w.dropped += bytes.Count(b, []byte{'\n'})
+			if w.dropped >= w.lines {
+				_, _ = fmt.Fprintf(w.status, "%s: none of the last %d lines has a timestamp, check the timestamp format of the host\n", w.prefix, w.lines)
+			}
+		}
+		if !ok || ts.Before(w.since) {
+			return len(b), nil
+		}
+		w.passing = true
+	}
+
+	return w.next.Write(b)
+}
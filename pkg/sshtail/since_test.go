@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"bytes"
+	"github.com/drognisep/sshtail/pkg/specfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSinceWriter(t *testing.T) {
+	/// Given
+	ts := &specfile.TimestampSpec{Layout: "rfc3339"}
+	require.NoError(t, ts.Validate())
+	var out, status bytes.Buffer
+	since := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := &sinceWriter{since: since, timestamp: ts, next: &out, prefix: "web", lines: 100, status: &status}
+
+	/// When
+	writeLines(t, w,
+		"  orphaned continuation\n",
+		"2023-01-01T09:59:59Z older\n",
+		"  older continuation\n",
+		"2023-01-01T10:00:00Z first\n",
+		"  continuation\n",
+		"2023-01-01T09:00:00Z out of order\n",
+	)
+
+	/// Then
+	assert.Equal(t, "2023-01-01T10:00:00Z first\n  continuation\n2023-01-01T09:00:00Z out of order\n", out.String(),
+		"everything from the first line since the time on should pass")
+	assert.Empty(t, status.String(), "there should be no warning if the backlog goes back far enough")
+}
+
+func TestSinceWriter_WarnsAboutShortBacklog(t *testing.T) {
+	/// Given
+	ts := &specfile.TimestampSpec{Layout: "rfc3339"}
+	require.NoError(t, ts.Validate())
+	var out, status bytes.Buffer
+	since := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := &sinceWriter{since: since, timestamp: ts, next: &out, prefix: "web", lines: 100, status: &status}
+
+	/// When
+	writeLines(t, w, "  continuation\n", "2023-01-01T10:05:00Z first\n", "2023-01-01T10:06:00Z second\n")
+
+	/// Then
+	assert.Equal(t, "2023-01-01T10:05:00Z first\n2023-01-01T10:06:00Z second\n", out.String())
+	assert.Equal(t, "web: the last 100 lines only go back to 2023-01-01T10:05:00Z, lines since 2023-01-01T10:00:00Z may be missing, use --lines to search further back\n",
+		status.String(), "the warning should be written once")
+}
+
+func TestSinceWriter_PatternAnchoredAtLineEnd(t *testing.T) {
+	/// Given
+	ts := &specfile.TimestampSpec{Layout: time.RFC3339, Pattern: `at (\S+)$`}
+	require.NoError(t, ts.Validate())
+	var out, status bytes.Buffer
+	since := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := &sinceWriter{since: since, timestamp: ts, next: &out, prefix: "web", lines: 100, status: &status}
+
+	/// When
+	writeLines(t, w, "older at 2023-01-01T09:59:59Z\n", "first at 2023-01-01T10:00:00Z\n")
+
+	/// Then
+	assert.Equal(t, "first at 2023-01-01T10:00:00Z\n", out.String(), "a pattern anchored at the end of the line should match")
+	assert.Empty(t, status.String())
+}
+
+func TestSinceWriter_WarnsAboutBacklogWithoutTimestamps(t *testing.T) {
+	/// Given
+	ts := &specfile.TimestampSpec{Layout: "rfc3339"}
+	require.NoError(t, ts.Validate())
+	var out, status bytes.Buffer
+	since := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := &sinceWriter{since: since, timestamp: ts, next: &out, prefix: "web", lines: 3, status: &status}
+
+	/// When
+	writeLines(t, w, "no timestamp\n", "no timestamp either\n", "still none\n", "after the backlog\n")
+
+	/// Then
+	assert.Empty(t, out.String())
+	assert.Equal(t, "web: none of the last 3 lines has a timestamp, check the timestamp format of the host\n",
+		status.String(), "the warning should be written once")
+}
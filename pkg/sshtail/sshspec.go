@@ -20,17 +20,25 @@ package sshtail
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/drognisep/sshtail/pkg/specfile"
 	"io"
+	"os"
+	"sync"
+	"time"
 )
 
-// setupClients validates the spec data and sets up TailSshClient instances.
-func setupClients(specData *specfile.SpecData) ([]*TailSshClient, error) {
+// setupClients validates the spec data and sets up TailSshClient instances. Hosts behind the same jump hosts share
+// the jump host connections of the pool.
+func setupClients(specData *specfile.SpecData, jumps *jumpPool) ([]*TailSshClient, error) {
 	clients := make([]*TailSshClient, 0, len(specData.Hosts))
 
 	for tag, host := range specData.Hosts {
-		client, err := NewTailSshClient(tag, host)
+		client, err := newTailSshClient(tag, host, jumps)
 		if err != nil {
+			for _, c := range clients {
+				_ = c.Close()
+			}
 			return nil, err
 		}
 
@@ -40,80 +48,346 @@ func setupClients(specData *specfile.SpecData) ([]*TailSshClient, error) {
 	return clients, nil
 }
 
-// ConsolidatedWriter receives messages from all of its tail session instances and writes them to its output stream.
+// ConsolidatedWriter receives events from all of its tail session instances and writes them to its output stream, or
+// passes them on to the channel returned by Events.
 type ConsolidatedWriter struct {
-	ch      chan string
-	clients []*TailSshClient
-	out     io.Writer
+	ch        chan LogEvent
+	events    chan LogEvent
+	clients   []*TailSshClient
+	jumps     *jumpPool
+	out       io.Writer
+	formatter Formatter
+	filter    *specfile.FilterSpec
+	filters   map[string][]*specfile.FilterSpec
+	status    io.Writer
+	lines     int
+	since     time.Time
+	// hosts are the tags of the hosts to tail, all hosts are tailed if it's nil.
+	hosts []string
+
+	// orderWindow is how long lines are held back to be ordered by their timestamps, they're not ordered if it's zero.
+	orderWindow time.Duration
+	timestamps  map[string]*specfile.TimestampSpec
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+	finished  chan struct{}
+}
+
+// Option configures optional behavior of a ConsolidatedWriter.
+type Option func(c *ConsolidatedWriter)
+
+// WithStatusOutput sets where status messages, like hosts going down and coming back, are written. They're written to
+// stderr by default.
+func WithStatusOutput(status io.Writer) Option {
+	return func(c *ConsolidatedWriter) {
+		c.status = status
+	}
+}
+
+// WithLines sets the number of lines shown from the end of every file when tailing starts, overriding the lines of
+// the hosts. Combined with WithSince, it limits how far back the files are searched.
+func WithLines(lines int) Option {
+	return func(c *ConsolidatedWriter) {
+		c.lines = lines
+	}
+}
+
+// WithSince only shows the lines of the backlog that were logged at or after the given time. Every host needs a
+// timestamp format to tell when a line was logged.
+func WithSince(since time.Time) Option {
+	return func(c *ConsolidatedWriter) {
+		c.since = since
+	}
+}
+
+// WithFormatter sets how events are formatted when they're written to the output. TextFormatter is used by default.
+func WithFormatter(formatter Formatter) Option {
+	return func(c *ConsolidatedWriter) {
+		c.formatter = formatter
+	}
+}
+
+// WithFilter only passes the lines of all hosts that pass the filter, in addition to the filters of each host. The
+// filter has to be validated.
+func WithFilter(filter *specfile.FilterSpec) Option {
+	return func(c *ConsolidatedWriter) {
+		c.filter = filter
+	}
+}
+
+// WithOrdering merges the lines of all hosts in the order of their timestamps instead of the order they arrive in.
+// Every line is held back for the window, so lines logged before it that are still on their way can be passed on
+// first. Lines arriving too late to be ordered are marked as late. Every host needs a timestamp format to tell when a
+// line was logged.
+func WithOrdering(window time.Duration) Option {
+	return func(c *ConsolidatedWriter) {
+		c.orderWindow = window
+	}
+}
+
+// WithHosts only tails the hosts with the given tags, instead of all hosts of the spec. The tags can be selected with
+// specfile.SpecData.Select.
+func WithHosts(tags []string) Option {
+	return func(c *ConsolidatedWriter) {
+		c.hosts = tags
+	}
 }
 
 // NewConsolidatedWriter creates tail sessions that are ready to Start and write to the provided writer.
-func NewConsolidatedWriter(specData *specfile.SpecData, output io.Writer) (*ConsolidatedWriter, error) {
-	clients, err := setupClients(specData)
+func NewConsolidatedWriter(specData *specfile.SpecData, output io.Writer, opts ...Option) (*ConsolidatedWriter, error) {
+	writer := &ConsolidatedWriter{
+		out:       output,
+		formatter: TextFormatter{},
+		status:    os.Stderr,
+		lines:     -1,
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(writer)
+	}
+
+	if writer.hosts != nil {
+		selected := &specfile.SpecData{SshConfig: specData.SshConfig, Hosts: map[string]*specfile.HostSpec{}}
+		for _, tag := range writer.hosts {
+			host, ok := specData.Hosts[tag]
+			if !ok {
+				return nil, fmt.Errorf("unknown host '%s'", tag)
+			}
+			selected.Hosts[tag] = host
+		}
+		specData = selected
+	}
+
+	if !writer.since.IsZero() {
+		for tag, host := range specData.Hosts {
+			if !host.Timestamp.Configured() {
+				return nil, fmt.Errorf("host spec %s: a timestamp format is required to show lines since a point in time", tag)
+			}
+		}
+	}
+
+	if writer.orderWindow > 0 {
+		writer.timestamps = map[string]*specfile.TimestampSpec{}
+		for tag, host := range specData.Hosts {
+			if !host.Timestamp.Configured() {
+				return nil, fmt.Errorf("host spec %s: a timestamp format is required to order lines by time", tag)
+			}
+			writer.timestamps[tag] = &host.Timestamp
+		}
+	}
+
+	writer.filters = map[string][]*specfile.FilterSpec{}
+	for tag, host := range specData.Hosts {
+		if host.Filters.Configured() {
+			writer.filters[tag] = append(writer.filters[tag], &host.Filters)
+		}
+		// Remote filters are applied again, since grep on the host may only narrow the lines down.
+		if host.RemoteFilter.Configured() {
+			writer.filters[tag] = append(writer.filters[tag], &host.RemoteFilter)
+		}
+	}
+
+	jumps := newJumpPool(writer.status)
+	clients, err := setupClients(specData, jumps)
 	if err != nil {
+		_ = jumps.Close()
 		return nil, err
 	}
 
-	writer := &ConsolidatedWriter{nil, clients, output}
+	for _, client := range clients {
+		client.status = writer.status
+		client.lines = writer.lines
+		client.since = writer.since
+	}
+
+	writer.clients = clients
+	writer.jumps = jumps
 	return writer, nil
 }
 
 // Close closes all tail sessions as well as the connected clients.
 func (c *ConsolidatedWriter) Close() error {
-	if c.ch == nil {
-		return nil
-	}
+	c.closeOnce.Do(func() {
+		close(c.closed)
 
-	for _, ts := range c.clients {
-		if !ts.Started() {
-			continue
+		for _, ts := range c.clients {
+			_ = ts.Close()
 		}
+		_ = c.jumps.Close()
 
-		_ = ts.Close()
-	}
-
-	close(c.ch)
-	c.ch = nil
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.ch == nil {
+			// Nothing will ever be written, so there's nothing to wait for.
+			if c.events != nil {
+				close(c.events)
+			}
+			close(c.finished)
+		}
+	})
 
 	return nil
 }
 
 // Start starts all tail sessions. In the event of an error, all already opened sessions are closed and an error is returned.
+// Hosts that drop after starting are reconnected in the background until the writer is closed.
 func (c *ConsolidatedWriter) Start(ctx context.Context) error {
+	c.mu.Lock()
 	if c.ch != nil {
+		c.mu.Unlock()
 		return errors.New("already started")
 	}
 
-	c.ch = make(chan string, 1024*len(c.clients))
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		return errors.New("already closed")
+	default:
+	}
+
+	ch := make(chan LogEvent, 1024*len(c.clients))
 	for _, client := range c.clients {
 		if client.Started() {
 			continue
 		}
 
-		err := client.StartSession(c.ch)
+		err := client.StartSession(ch)
 		if err != nil {
+			c.mu.Unlock()
 			_ = c.Close()
 			return err
 		}
 	}
+	c.ch = ch
+	events := c.events
+	c.mu.Unlock()
 
-	go func(ctx context.Context) {
-		for {
-			select {
-			case line, ok := <-c.ch:
-				if !ok {
-					_ = c.Close()
-					return
-				}
+	// The channel is only closed once every client stopped, so no session writes to it afterwards.
+	var wg sync.WaitGroup
+	for _, client := range c.clients {
+		wg.Add(1)
+		go func(client *TailSshClient) {
+			defer wg.Done()
+			client.supervise(ch)
+		}(client)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
 
-				// Write to output buffer
-				_, _ = c.out.Write([]byte(line))
-			case <-ctx.Done():
-				_ = c.Close()
-				return
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.Close()
+		case <-c.closed:
+		}
+	}()
+
+	go func() {
+		defer close(c.finished)
+		if events != nil {
+			defer close(events)
+		}
+
+		var seq uint64
+		emit := func(e LogEvent) {
+			seq++
+			e.Seq = seq
+			c.emit(e, events)
+		}
+
+		if c.orderWindow <= 0 {
+			for e := range ch {
+				if c.pass(e) {
+					emit(e)
+				}
 			}
+			return
 		}
-	}(ctx)
+
+		passed := make(chan LogEvent)
+		go func() {
+			defer close(passed)
+			for e := range ch {
+				if c.pass(e) {
+					passed <- e
+				}
+			}
+		}()
+		newOrderer(c.orderWindow, c.timestamps).run(passed, emit)
+	}()
 
 	return nil
 }
+
+// emit passes an event on to the events channel if there is one, or writes it to the output otherwise.
+func (c *ConsolidatedWriter) emit(e LogEvent, events chan<- LogEvent) {
+	if events != nil {
+		select {
+		case events <- e:
+		case <-c.closed:
+			// Nobody may be reading anymore, so the rest is dropped.
+		}
+		return
+	}
+
+	b, err := c.formatter.Format(e)
+	if err != nil {
+		_, _ = fmt.Fprintf(c.status, "%s: failed to format line: %v\n", e.Source(), err)
+		return
+	}
+	_, _ = c.out.Write(b)
+}
+
+// pass returns true if the line passes both the filters of its host and the filter for all hosts.
+func (c *ConsolidatedWriter) pass(e LogEvent) bool {
+	for _, f := range c.filters[e.Tag] {
+		if !f.Match(e.Line) {
+			return false
+		}
+	}
+
+	return c.filter == nil || c.filter.Match(e.Line)
+}
+
+// Events returns a channel that receives every tailed line as an event, instead of formatting it to the output. It has
+// to be called before Start, and the channel is closed once the writer finished. An error is returned if the writer was
+// already started or closed, since the lines are being written to the output then.
+func (c *ConsolidatedWriter) Events() (<-chan LogEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.events != nil {
+		return c.events, nil
+	}
+
+	if c.ch != nil {
+		return nil, errors.New("already started")
+	}
+
+	select {
+	case <-c.closed:
+		return nil, errors.New("already closed")
+	default:
+	}
+
+	c.events = make(chan LogEvent, 1024)
+	return c.events, nil
+}
+
+// Wait blocks until the writer is closed, or until all hosts disconnected for good. An error is returned in the
+// latter case.
+func (c *ConsolidatedWriter) Wait() error {
+	<-c.finished
+
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		return errors.New("all hosts disconnected")
+	}
+}
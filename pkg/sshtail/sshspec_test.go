@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2020 Joseph Saylor <doug@saylorsolutions.com>
+ * Copyright (c) 2023 Lorenzo Delgado <lnsdev@proton.me>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sshtail
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// newTestWriter creates a writer without any hosts, so it doesn't have to connect anywhere.
+func newTestWriter() *ConsolidatedWriter {
+	return &ConsolidatedWriter{
+		formatter: TextFormatter{},
+		status:    os.Stderr,
+		jumps:     newJumpPool(os.Stderr),
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+}
+
+func TestConsolidatedWriter_Events(t *testing.T) {
+	/// Given
+	writer := newTestWriter()
+
+	/// When
+	events, err := writer.Events()
+	require.NoError(t, err)
+	again, err := writer.Events()
+	require.NoError(t, err)
+	require.NoError(t, writer.Start(context.Background()))
+	afterStart, err := writer.Events()
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	/// Then
+	assert.Equal(t, events, again)
+	assert.Equal(t, events, afterStart, "the channel asked for before starting should still be returned")
+	_, ok := <-events
+	assert.False(t, ok, "the channel should be closed once the writer finished")
+}
+
+func TestConsolidatedWriter_EventsAfterStart(t *testing.T) {
+	/// Given
+	writer := newTestWriter()
+	require.NoError(t, writer.Start(context.Background()))
+	defer writer.Close()
+
+	/// When
+	events, err := writer.Events()
+
+	/// Then
+	assert.Error(t, err, "lines are already written to the output")
+	assert.Nil(t, events)
+}
+
+func TestConsolidatedWriter_EventsAfterClose(t *testing.T) {
+	/// Given
+	writer := newTestWriter()
+	require.NoError(t, writer.Close())
+
+	/// When
+	_, err := writer.Events()
+
+	/// Then
+	assert.Error(t, err)
+}
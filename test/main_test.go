@@ -25,6 +25,7 @@ import (
 	"github.com/drognisep/sshtail/pkg/sshtail"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -45,21 +46,26 @@ func Test(t *testing.T) {
 	defer itlib.StopTestServer(t, ctx, serverB)
 
 	/// Given
+	knownHostsFile := path.Join(t.TempDir(), "known_hosts")
 	specData := &specfile.SpecData{
 		Hosts: map[string]*specfile.HostSpec{
 			"serverA": {
-				Hostname:     serverA.Hostname,
-				Username:     serverA.Username,
-				File:         "/app/logs/test.log",
-				IdentityFile: serverA.IdentityFile,
-				Port:         serverA.Port,
+				Hostname:       serverA.Hostname,
+				Username:       serverA.Username,
+				File:           "/app/logs/test.log",
+				IdentityFile:   serverA.IdentityFile,
+				KnownHostsFile: knownHostsFile,
+				HostKeyPolicy:  specfile.HostKeyPolicyAcceptNew,
+				Port:           serverA.Port,
 			},
 			"serverB": {
-				Hostname:     serverB.Hostname,
-				Username:     serverB.Username,
-				File:         "/app/logs/test.log",
-				IdentityFile: serverB.IdentityFile,
-				Port:         serverB.Port,
+				Hostname:       serverB.Hostname,
+				Username:       serverB.Username,
+				File:           "/app/logs/test.log",
+				IdentityFile:   serverB.IdentityFile,
+				KnownHostsFile: knownHostsFile,
+				HostKeyPolicy:  specfile.HostKeyPolicyAcceptNew,
+				Port:           serverB.Port,
 			},
 		},
 	}